@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+var ErrorInvalidName = errors.New("Collection names may only contain letters, digits, '-' and '_', and can't start with '_'")
+
+var (
+	// initialData is a copy of the data as it was loaded from the JSON file, which `POST /_admin/reset` restores. It
+	// is protected by `dataMutex`.
+	initialData = make(BackingData)
+
+	// snapshots holds the copies of the data saved with `POST /_admin/snapshots/:name`, by name
+	snapshots      = make(map[string]BackingData)
+	snapshotsMutex sync.Mutex
+)
+
+// collectionNamePattern matches the names collections can be created with. Names starting with `_` are reserved for
+// routes such as `/_admin`, and dots would be ambiguous in dotted paths such as QREST_INDEXES.
+//
+var collectionNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// validCollectionName returns whether a collection can be created with `name` without clashing with other routes.
+// Top level keys of the JSON file with any other name aren't served.
+//
+func validCollectionName(name string) bool {
+	return collectionNamePattern.MatchString(name) && name != "db"
+}
+
+// addAdminRoutes adds the routes which change the shape of the data set while the server is running:
+//
+//    GET /_admin/collections (returns the name and number of records of every collection)
+//    POST /_admin/collections (creates a collection, e.g. {"name": "widgets", "records": [{"id": 1}]})
+//    DELETE /_admin/collections/:name (deletes a collection and all of its records)
+//    POST /_admin/reset (restores the data as it was loaded from the JSON file)
+//    GET /_admin/snapshots (returns the names of the snapshots)
+//    POST /_admin/snapshots/:name (saves a copy of the data as a snapshot, replacing any with the same name)
+//    POST /_admin/snapshots/:name/restore (restores the data saved in a snapshot)
+//    DELETE /_admin/snapshots/:name (deletes a snapshot)
+//
+// When `config.AutoCreate` is set, POSTing a record to a collection which doesn't exist also creates it (see
+// autoCreateCollection).
+//
+//
+func addAdminRoutes(router *httprouter.Router) {
+	router.GET("/_admin/collections", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		unlock := lockAllCollections()
+		defer unlock()
+
+		itemTypes := serverData.ItemTypes()
+		sort.Strings(itemTypes)
+
+		collections := []map[string]interface{}{}
+
+		for _, itemType := range itemTypes {
+			if rows, err := serverData.ItemType(itemType); err == nil {
+				collections = append(collections, map[string]interface{}{"name": itemType, "count": len(rows)})
+			}
+		}
+
+		genericJsonResponse(w, r, collections)
+	})
+
+	router.POST("/_admin/collections", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		data, err := readRequestData(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		name, _ := data["name"].(string)
+		if !validCollectionName(name) {
+			errorJsonResponse(w, r, http.StatusBadRequest, ErrorInvalidName)
+			return
+		}
+
+		rows, ok := data["records"].([]interface{})
+		if !ok && data["records"] != nil {
+			errorJsonResponse(w, r, http.StatusBadRequest, fmt.Errorf("records must be an array"))
+			return
+		}
+
+		for _, row := range rows {
+			if _, ok := row.(map[string]interface{}); !ok {
+				errorJsonResponse(w, r, http.StatusBadRequest, fmt.Errorf("records must be objects"))
+				return
+			}
+		}
+
+		dataMutex.Lock()
+		defer dataMutex.Unlock()
+
+		if err := serverData.CreateCollection(name, rows); err != nil {
+			errorJsonResponse(w, r, http.StatusConflict, err)
+			return
+		}
+
+		markDirty()
+		routesChanged(r)
+
+		w.Header().Set("Location", config.Prefix+"/"+name)
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	router.DELETE("/_admin/collections/:name", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		dataMutex.Lock()
+		defer dataMutex.Unlock()
+
+		switch err := serverData.DropCollection(ps.ByName("name")); err {
+		case nil:
+			markDirty()
+			routesChanged(r)
+			w.WriteHeader(http.StatusNoContent)
+		case ErrorNotFound:
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			errorJsonResponse(w, r, http.StatusConflict, err)
+		}
+	})
+
+	router.POST("/_admin/reset", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if err := restoreData(r, initialData); err != nil {
+			errorJsonResponse(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	router.GET("/_admin/snapshots", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		snapshotsMutex.Lock()
+		defer snapshotsMutex.Unlock()
+
+		names := make([]string, 0, len(snapshots))
+		for name := range snapshots {
+			names = append(names, name)
+		}
+
+		sort.Strings(names)
+
+		genericJsonResponse(w, r, names)
+	})
+
+	router.POST("/_admin/snapshots/:name", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		unlock := lockAllCollections()
+		snapshot := serverData.Copy()
+		unlock()
+
+		snapshotsMutex.Lock()
+		defer snapshotsMutex.Unlock()
+
+		snapshots[ps.ByName("name")] = snapshot
+
+		w.WriteHeader(http.StatusCreated)
+	})
+
+	router.POST("/_admin/snapshots/:name/restore", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		snapshotsMutex.Lock()
+		snapshot, ok := snapshots[ps.ByName("name")]
+		snapshotsMutex.Unlock()
+
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if err := restoreData(r, snapshot); err != nil {
+			errorJsonResponse(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	router.DELETE("/_admin/snapshots/:name", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		snapshotsMutex.Lock()
+		defer snapshotsMutex.Unlock()
+
+		if _, ok := snapshots[ps.ByName("name")]; !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		delete(snapshots, ps.ByName("name"))
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	if config.AutoCreate {
+		router.NotFound = autoCreateCollection
+	}
+}
+
+// restoreData replaces the data with a copy of `data` while handling `r`, rebuilding the routes since the
+// collections may have changed. Rather than logging the whole data set, `data` is written to the JSON file first
+// (unless the flush policy is "never"), which resets the write-ahead log. If that fails, nothing is restored.
+//
+func restoreData(r *http.Request, data BackingData) error {
+	flushMutex.Lock()
+	defer flushMutex.Unlock()
+
+	dataMutex.Lock()
+	defer dataMutex.Unlock()
+
+	if config.FlushPolicy != FlushNever {
+		if err := writeSnapshot(JsonFilePath, data); err != nil {
+			return err
+		}
+	}
+
+	serverData.RestoreData(data)
+	routesChanged(r)
+
+	if config.FlushPolicy == FlushNever {
+		markDirty()
+	} else {
+		atomic.StoreInt32(&dirty, 0)
+	}
+
+	return nil
+}
+
+// autoCreateCollection handles requests which weren't routed when `config.AutoCreate` is set. A POST to `/name`
+// creates the collection `name` with the posted record, like a POST to an existing collection would add it. Anything
+// else is not found.
+//
+func autoCreateCollection(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if r.Method != "POST" || !validCollectionName(name) {
+		http.NotFound(w, r)
+		return
+	}
+
+	data, err := readRequestData(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	dataMutex.Lock()
+	defer dataMutex.Unlock()
+
+	if err := serverData.ValidateRecord(name, data); err != nil {
+		errorJsonResponse(w, r, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	// Another request may have created it since this one was routed, in which case the record is just added
+	if err := serverData.CreateCollection(name, nil); err == ErrorExists {
+		if _, err := serverData.ItemType(name); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	insertRecord(name, data)
+	routesChanged(r)
+
+	statusJsonResponse(w, r, http.StatusCreated, data)
+}
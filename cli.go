@@ -0,0 +1,514 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strings"
+)
+
+const cliUsage = `Usage: qrest <command> [flags] [arguments]
+
+Commands:
+  serve [db.json]      serve the records in db.json (the command may be left out: qrest db.json)
+  validate [db.json]   check that db.json can be served, exiting with status 1 if it can't
+  export [db.json]     write db.json including the changes in its write-ahead log which haven't been flushed
+  init [db.json]       create a JSON file with example records
+
+The file may be left out if the configuration file (qrest.json, qrest.yaml or qrest.yml in the working directory,
+or the one given with -config) declares it. Run "qrest <command> -h" for the flags of a command.
+`
+
+// exampleData is written by the init command
+//
+const exampleData = `{
+  "posts": [
+    { "id": 1, "title": "Hello qrest", "author": "qrest" }
+  ],
+  "comments": [
+    { "id": 1, "body": "Nice post", "postId": 1 }
+  ],
+  "profile": { "name": "qrest" }
+}
+`
+
+// errorUsage is returned by commands given the wrong arguments. The flag package has already printed the problem
+// and the usage of the command when a flag is wrong.
+//
+var errorUsage = errors.New("invalid arguments")
+
+// cliCommands maps each command to the function running it with the arguments following the command
+//
+var cliCommands = map[string]func(args []string) error{
+	"serve":    serveCommand,
+	"validate": validateCommand,
+	"export":   exportCommand,
+	"init":     initCommand,
+}
+
+// runCli runs the command named by the first of `args` and returns the exit status: 0 on success, 1 if the command
+// failed and 2 if it was used incorrectly
+//
+func runCli(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, cliUsage)
+		return 2
+	}
+
+	var err error
+
+	switch name := args[0]; {
+	case name == "-h" || name == "-help" || name == "--help" || name == "help":
+		fmt.Fprint(os.Stdout, cliUsage)
+		return 0
+	case cliCommands[name] != nil:
+		err = cliCommands[name](args[1:])
+	case strings.HasPrefix(name, "-"):
+		fmt.Fprintf(os.Stderr, "Unknown flag %s\n\n%s", name, cliUsage)
+		return 2
+	default:
+		// `qrest db.json` serves the file, as it always has
+		err = serveCommand(args)
+	}
+
+	switch err {
+	case nil:
+		return 0
+	case flag.ErrHelp:
+		return 0
+	case errorUsage:
+		return 2
+	}
+
+	fmt.Fprintln(os.Stderr, "qrest:", err)
+
+	return 1
+}
+
+// newFlagSet creates the flags of a command, whose arguments are described by `arguments` in its usage
+//
+func newFlagSet(name, arguments string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: qrest %s [flags] %s\n", name, arguments)
+
+		hasFlags := false
+		flags.VisitAll(func(*flag.Flag) {
+			hasFlags = true
+		})
+
+		if hasFlags {
+			fmt.Fprintln(flags.Output(), "\nFlags:")
+			flags.PrintDefaults()
+		}
+	}
+
+	return flags
+}
+
+// parseFlags parses `args` with `flags`, allowing flags to come after the positional arguments (as in
+// `qrest serve db.json --port 8080`), and returns the positional arguments
+//
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+
+	for {
+		if err := flags.Parse(args); err == flag.ErrHelp {
+			return nil, err
+		} else if err != nil {
+			return nil, errorUsage
+		}
+
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// configFlag adds the -config flag to a command which uses the configuration
+//
+func configFlag(flags *flag.FlagSet) *string {
+	return flags.String("config", os.Getenv("QREST_CONFIG"), "configuration `file`, qrest.json, qrest.yaml or qrest.yml if it exists (QREST_CONFIG)")
+}
+
+// loadConfiguration loads the configuration file, or the first of configFileNames which exists if `fname` is empty,
+// and then the environment, which overrides it
+//
+func loadConfiguration(fname string) error {
+	if fname == "" {
+		fname = findConfigFile()
+	}
+
+	if fname != "" {
+		if err := loadConfigFile(fname); err != nil {
+			return err
+		}
+	}
+
+	ConfigFilePath = fname
+
+	loadConfigFromEnv()
+
+	return nil
+}
+
+// dataFile returns the JSON file given on the command line, or the one in the configuration if there is none
+//
+func dataFile(flags *flag.FlagSet, positional []string) (string, error) {
+	if len(positional) == 1 {
+		return positional[0], nil
+	}
+
+	if config.DataFile != "" {
+		return config.DataFile, nil
+	}
+
+	fmt.Fprintln(flags.Output(), "No JSON file was given and the configuration doesn't declare one")
+	flags.Usage()
+
+	return "", errorUsage
+}
+
+// checkArguments returns errorUsage (after printing the usage) unless between `min` and `max` positional arguments
+// were given
+//
+func checkArguments(flags *flag.FlagSet, positional []string, min, max int) error {
+	if len(positional) >= min && len(positional) <= max {
+		return nil
+	}
+
+	fmt.Fprintf(flags.Output(), "Expected %s, got %d arguments\n", pluralArguments(min, max), len(positional))
+	flags.Usage()
+
+	return errorUsage
+}
+
+func pluralArguments(min, max int) string {
+	switch {
+	case min == max && min == 1:
+		return "1 argument"
+	case min == max:
+		return fmt.Sprintf("%d arguments", min)
+	}
+
+	return fmt.Sprintf("%d to %d arguments", min, max)
+}
+
+// serveCommand serves the JSON file until the process is stopped. Flags which are given override the configuration.
+//
+func serveCommand(args []string) error {
+	defaults := defaultConfig()
+
+	flags := newFlagSet("serve", "[db.json]")
+	configFile := configFlag(flags)
+	port := flags.String("port", defaults.Port, "`port` to listen on (PORT)")
+	host := flags.String("host", defaults.Host, "`host` to listen on, all interfaces if empty (HOST)")
+	prefix := flags.String("prefix", defaults.Prefix, "`path` to serve every route under, e.g. /api (QREST_PREFIX)")
+	readOnly := flags.Bool("read-only", defaults.ReadOnly, "reject requests which change the data (QREST_READ_ONLY)")
+	delay := flags.Duration("delay", defaults.Delay, "`duration` to delay every response by, e.g. 500ms (QREST_DELAY)")
+	routesFile := flags.String("routes", "", "JSON `file` of route rewrites (QREST_ROUTES)")
+	autoCreate := flags.Bool("auto-create", defaults.AutoCreate, "create collections when records are POSTed to them (QREST_AUTO_CREATE)")
+	watch := flags.Bool("watch", defaults.Watch, "reload the JSON file and the configuration file when they change (QREST_WATCH)")
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if err := checkArguments(flags, positional, 0, 1); err != nil {
+		return err
+	}
+
+	if *delay < 0 {
+		return fmt.Errorf("invalid delay %s", *delay)
+	}
+
+	// The configuration is rebuilt in the same way when the configuration file is reloaded
+	rebuildConfig = func() error {
+		config = defaultConfig()
+
+		if err := loadConfiguration(*configFile); err != nil {
+			return err
+		}
+
+		var routesErr error
+
+		flags.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "port":
+				config.Port = *port
+			case "host":
+				config.Host = *host
+			case "prefix":
+				config.Prefix = normalizePrefix(*prefix)
+			case "read-only":
+				config.ReadOnly = *readOnly
+			case "delay":
+				config.Delay = *delay
+			case "routes":
+				config.Routes, routesErr = loadRoutesFile(*routesFile)
+			case "auto-create":
+				config.AutoCreate = *autoCreate
+			case "watch":
+				config.Watch = *watch
+			}
+		})
+
+		return routesErr
+	}
+
+	if err := rebuildConfig(); err != nil {
+		return err
+	}
+
+	fname, err := dataFile(flags, positional)
+	if err != nil {
+		return err
+	}
+
+	parseJsonFile(fname)
+
+	return StartServer(net.JoinHostPort(config.Host, config.Port))
+}
+
+// validateCommand checks that the JSON file can be loaded and that its records are consistent, listing every
+// problem found
+//
+func validateCommand(args []string) error {
+	flags := newFlagSet("validate", "[db.json]")
+	configFile := configFlag(flags)
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if err := checkArguments(flags, positional, 0, 1); err != nil {
+		return err
+	}
+
+	if err := loadConfiguration(*configFile); err != nil {
+		return err
+	}
+
+	fname, err := dataFile(flags, positional)
+	if err != nil {
+		return err
+	}
+
+	data, _, err := loadJsonFile(fname)
+	if err != nil {
+		return err
+	}
+
+	problems := validateData(data)
+	for _, problem := range problems {
+		fmt.Fprintln(os.Stdout, problem)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s has %d problems", fname, len(problems))
+	}
+
+	fmt.Fprintf(os.Stdout, "%s is valid\n", fname)
+
+	return nil
+}
+
+// validateData returns a description of everything in `data` which can't be served as it is: top level values which
+// aren't collections or resources, records which aren't objects, lack a valid unique ID or don't match their schema,
+// and foreign keys of relations with a policy other than OnDeleteNone which refer to records that don't exist
+//
+func validateData(data BackingData) []string {
+	problems := []string{}
+	itemTypes := data.ItemTypes()
+	sort.Strings(itemTypes)
+
+	for _, itemType := range itemTypes {
+		if !validCollectionName(itemType) {
+			problems = append(problems, fmt.Sprintf("%s: the name is reserved or can't be used in a route", itemType))
+			continue
+		}
+
+		collection, ok := data[itemType].(*Collection)
+		if !ok {
+			resource, ok := data[itemType].(map[string]interface{})
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s: top level values must be an array of records or an object", itemType))
+			} else if schema := config.Collections[itemType].Schema; schema != nil {
+				for _, problem := range schema.Validate(resource) {
+					problems = append(problems, fmt.Sprintf("%s: %s", itemType, problem))
+				}
+			}
+
+			continue
+		}
+
+		seen := make(map[string]bool)
+
+		for i, row := range collection.Rows {
+			record, ok := row.(map[string]interface{})
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s[%d]: not an object", itemType, i))
+				continue
+			}
+
+			id, ok := record[collection.idField]
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s[%d]: missing %q", itemType, i, collection.idField))
+				continue
+			}
+
+			key, ok := idKey(id)
+			if !ok {
+				problems = append(problems, fmt.Sprintf("%s[%d]: %q must be a string or a number", itemType, i, collection.idField))
+				continue
+			}
+
+			if seen[key] {
+				problems = append(problems, fmt.Sprintf("%s[%d]: duplicate %s %s", itemType, i, collection.idField, key))
+			}
+
+			seen[key] = true
+
+			if schema := config.Collections[itemType].Schema; schema != nil {
+				for _, problem := range schema.Validate(record) {
+					problems = append(problems, fmt.Sprintf("%s[%d]: %s", itemType, i, problem))
+				}
+			}
+		}
+	}
+
+	for _, relation := range buildRelations(data) {
+		if relation.OnDelete == OnDeleteNone {
+			continue
+		}
+
+		rows, err := data.ItemType(relation.Child)
+		if err != nil {
+			continue
+		}
+
+		for i, row := range rows {
+			record, ok := row.(map[string]interface{})
+			if !ok || record[relation.ForeignKey] == nil {
+				continue
+			}
+
+			if _, err := data.RecordWithId(relation.Parent, record[relation.ForeignKey]); err != nil {
+				problems = append(problems, fmt.Sprintf("%s[%d]: %s %v does not refer to a record of %s", relation.Child, i, relation.ForeignKey, record[relation.ForeignKey], relation.Parent))
+			}
+		}
+	}
+
+	return problems
+}
+
+// exportCommand writes the JSON file with the changes recorded in its write-ahead log applied, which is what would
+// be served if the server were started with it
+//
+func exportCommand(args []string) error {
+	flags := newFlagSet("export", "[db.json]")
+	configFile := configFlag(flags)
+	out := flags.String("out", "", "`file` to write to instead of stdout")
+	pretty := flags.Bool("pretty", false, "indent the JSON")
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if err := checkArguments(flags, positional, 0, 1); err != nil {
+		return err
+	}
+
+	if err := loadConfiguration(*configFile); err != nil {
+		return err
+	}
+
+	fname, err := dataFile(flags, positional)
+	if err != nil {
+		return err
+	}
+
+	data, jsonData, err := loadJsonFile(fname)
+	if err != nil {
+		return err
+	}
+
+	entries, _, err := readWal(walPath(fname), dataChecksum(jsonData))
+	if err != nil {
+		return err
+	}
+
+	if err := data.replayWal(entries); err != nil {
+		return err
+	}
+
+	exported, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if *pretty {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, exported, "", "  "); err != nil {
+			return err
+		}
+
+		exported = indented.Bytes()
+	}
+
+	exported = append(exported, '\n')
+
+	if *out != "" {
+		return writeFileAtomically(*out, exported)
+	}
+
+	_, err = io.Copy(os.Stdout, bytes.NewReader(exported))
+
+	return err
+}
+
+// initCommand writes exampleData to a new JSON file, db.json unless another is given
+//
+func initCommand(args []string) error {
+	flags := newFlagSet("init", "[db.json]")
+	force := flags.Bool("force", false, "overwrite the file if it exists")
+
+	positional, err := parseFlags(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if err := checkArguments(flags, positional, 0, 1); err != nil {
+		return err
+	}
+
+	fname := "db.json"
+	if len(positional) == 1 {
+		fname = positional[0]
+	}
+
+	if _, err := os.Stat(fname); err == nil && !*force {
+		return fmt.Errorf("%s already exists, use -force to overwrite it", fname)
+	}
+
+	if err := writeFileAtomically(fname, []byte(exampleData)); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stdout, "Created %s, run \"qrest serve %s\" to serve it\n", fname, fname)
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	port := flags.String("port", "3000", "")
+	readOnly := flags.Bool("read-only", false, "")
+
+	positional, err := parseFlags(flags, []string{"--read-only", "db.json", "--port", "8080", "other.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(positional, []string{"db.json", "other.json"}) {
+		t.Errorf("Expected both files as positional arguments, got %v", positional)
+	}
+
+	if *port != "8080" || !*readOnly {
+		t.Errorf("Expected flags after the file to be parsed, got port %s and read-only %t", *port, *readOnly)
+	}
+}
+
+func TestRunCliUsage(t *testing.T) {
+	tests := []struct {
+		args   []string
+		status int
+	}{
+		{[]string{}, 2},
+		{[]string{"--help"}, 0},
+		{[]string{"--bogus"}, 2},
+		{[]string{"validate"}, 2},
+		{[]string{"validate", "a.json", "b.json"}, 2},
+		{[]string{"validate", "--bogus", "a.json"}, 2},
+		{[]string{"validate", "-h"}, 0},
+		{[]string{"validate", "does-not-exist.json"}, 1},
+	}
+
+	for _, test := range tests {
+		if status := runCli(test.args); status != test.status {
+			t.Errorf("Expected status %d for %v, got %d", test.status, test.args, status)
+		}
+	}
+}
+
+func TestValidateData(t *testing.T) {
+	previous := config
+	defer func() {
+		config = previous
+	}()
+
+	config.OnDelete = OnDeleteRestrict
+
+	data := BackingData{
+		"posts": newCollection("posts", []interface{}{
+			map[string]interface{}{"id": int64(1)},
+			map[string]interface{}{"id": "1"},
+			map[string]interface{}{"title": "No ID"},
+			map[string]interface{}{"id": true},
+			"Not a record",
+		}),
+		"comments": newCollection("comments", []interface{}{
+			map[string]interface{}{"id": int64(1), "postId": int64(1)},
+			map[string]interface{}{"id": int64(2), "postId": int64(5)},
+			map[string]interface{}{"id": int64(3), "postId": nil},
+		}),
+		"profile": map[string]interface{}{"name": "Foo"},
+		"version": int64(1),
+		"_admin":  newCollection("_admin", []interface{}{map[string]interface{}{"id": int64(1)}}),
+		"db":      map[string]interface{}{},
+	}
+
+	expected := []string{
+		`_admin: the name is reserved or can't be used in a route`,
+		`db: the name is reserved or can't be used in a route`,
+		`comments[1]: postId 5 does not refer to a record of posts`,
+		`posts[1]: duplicate id 1`,
+		`posts[2]: missing "id"`,
+		`posts[3]: "id" must be a string or a number`,
+		`posts[4]: not an object`,
+		`version: top level values must be an array of records or an object`,
+	}
+
+	problems := validateData(data)
+
+	for _, problem := range expected {
+		found := false
+		for _, actual := range problems {
+			found = found || actual == problem
+		}
+
+		if !found {
+			t.Errorf("Expected problem %q, got %q", problem, problems)
+		}
+	}
+
+	if len(problems) != len(expected) {
+		t.Errorf("Expected %d problems, got %d: %q", len(expected), len(problems), problems)
+	}
+
+	valid := BackingData{"posts": newCollection("posts", collectionTestRows(3))}
+	if problems := validateData(valid); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %q", problems)
+	}
+}
+
+func TestExportCommand(t *testing.T) {
+	dir := walTestDir(t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "db.json")
+	jsonData := []byte(`{"posts": [{"id": 1, "title": "Foo"}]}`)
+	ioutil.WriteFile(filename, jsonData, 0644)
+
+	log, err := openWal(walPath(filename), dataChecksum(jsonData), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log.append(walEntry{Op: walAdd, Type: "posts", Record: map[string]interface{}{"id": int64(2), "title": "Bar"}})
+	log.Close()
+
+	out := filepath.Join(dir, "export.json")
+	if status := runCli([]string{"export", filename, "--out", out}); status != 0 {
+		t.Fatalf("Expected status 0, got %d", status)
+	}
+
+	exported, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var data map[string][]map[string]interface{}
+	if err := json.Unmarshal(exported, &data); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(data["posts"]) != 2 || data["posts"][1]["title"] != "Bar" {
+		t.Errorf("Expected the logged record to be exported, got %s", exported)
+	}
+}
+
+func TestInitCommand(t *testing.T) {
+	dir := walTestDir(t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "db.json")
+
+	if status := runCli([]string{"init", filename}); status != 0 {
+		t.Fatalf("Expected status 0, got %d", status)
+	}
+
+	data, _, err := loadJsonFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if problems := validateData(data); len(problems) != 0 {
+		t.Errorf("Expected the example data to be valid, got %q", problems)
+	}
+
+	ioutil.WriteFile(filename, []byte(`{}`), 0644)
+
+	if status := runCli([]string{"init", filename}); status != 1 {
+		t.Errorf("Expected init to refuse to overwrite the file, got status %d", status)
+	}
+
+	if status := runCli([]string{"init", "--force", filename}); status != 0 {
+		t.Errorf("Expected init -force to overwrite the file, got status %d", status)
+	}
+
+	if jsonData, _ := ioutil.ReadFile(filename); !strings.Contains(string(jsonData), "posts") {
+		t.Errorf("Expected the file to be overwritten, got %s", jsonData)
+	}
+}
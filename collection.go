@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// Collection is an array of records along with an index from each record's ID (see idKey) to its position, so
+// that records can be found without scanning the array, and any secondary indexes declared for the collection
+// (see fieldIndex). It is serialized as the plain array.
+//
+// The collection must be locked with lockCollections while it is in use.
+//
+type Collection struct {
+	Rows []interface{}
+
+	idField string
+	ids     map[string]int
+	indexes map[string]*fieldIndex
+
+	// maxId is the highest integer ID when the collection was loaded, or the last ID generated by nextId
+	maxId int64
+	mutex sync.RWMutex
+}
+
+// newCollection indexes `rows`, which are the records of `itemType`
+//
+func newCollection(itemType string, rows []interface{}) *Collection {
+	collection := &Collection{
+		Rows:    rows,
+		idField: primaryKey(itemType),
+		ids:     make(map[string]int, len(rows)),
+		indexes: make(map[string]*fieldIndex),
+	}
+
+	for i, row := range rows {
+		collection.indexAt(i)
+
+		record, _ := row.(map[string]interface{})
+		if id, ok := record[collection.idField].(int64); ok && id > collection.maxId {
+			collection.maxId = id
+		}
+	}
+
+	for _, path := range config.Collections[itemType].Indexes {
+		collection.addIndex(path)
+	}
+
+	return collection
+}
+
+func (c *Collection) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.Rows)
+}
+
+// indexOf returns the position of the record with the given ID
+//
+func (c *Collection) indexOf(id interface{}) (int, bool) {
+	key, ok := idKey(id)
+	if !ok {
+		return -1, false
+	}
+
+	index, ok := c.ids[key]
+
+	return index, ok
+}
+
+// indexAt adds the record at `index` to the ID index. If another record already has the same ID, the first one
+// keeps it.
+//
+func (c *Collection) indexAt(index int) {
+	record, _ := c.Rows[index].(map[string]interface{})
+
+	key, ok := idKey(record[c.idField])
+	if !ok {
+		logger.Errorf("ID either not present for record at index %d or it's unknown type\n", index)
+		return
+	}
+
+	if existing, ok := c.ids[key]; !ok || existing > index {
+		c.ids[key] = index
+	}
+}
+
+// unindexAt removes the record at `index` from the ID index
+//
+func (c *Collection) unindexAt(index int) {
+	record, _ := c.Rows[index].(map[string]interface{})
+
+	if key, ok := idKey(record[c.idField]); ok && c.ids[key] == index {
+		delete(c.ids, key)
+	}
+}
+
+func (c *Collection) add(record map[string]interface{}) {
+	c.Rows = append(c.Rows, record)
+	c.indexAt(len(c.Rows) - 1)
+
+	for _, index := range c.indexes {
+		index.insert(record, len(c.Rows)-1)
+	}
+}
+
+// removeAt deletes the record at `index`. The records after it move down by one, so their positions are
+// re-indexed. A record with the same ID as the deleted one (if any) takes its place in the index. Deleting is
+// therefore linear in the number of records after `index`, and only deleting the last record takes constant time.
+//
+func (c *Collection) removeAt(index int) {
+	c.unindexAt(index)
+
+	removed, _ := c.Rows[index].(map[string]interface{})
+	for _, fieldIndex := range c.indexes {
+		fieldIndex.remove(removed, index)
+		fieldIndex.shift(index)
+	}
+
+	c.Rows = append(c.Rows[:index], c.Rows[index+1:]...)
+
+	for i := index; i < len(c.Rows); i++ {
+		record, _ := c.Rows[i].(map[string]interface{})
+
+		key, ok := idKey(record[c.idField])
+		if !ok {
+			continue
+		}
+
+		if previous, indexed := c.ids[key]; !indexed || previous == i+1 {
+			c.ids[key] = i
+		}
+	}
+}
+
+// replaceAt swaps the record at `index` for `record`, updating the index if its ID changed
+//
+func (c *Collection) replaceAt(index int, record map[string]interface{}) {
+	c.unindexAt(index)
+
+	replaced, _ := c.Rows[index].(map[string]interface{})
+	for _, fieldIndex := range c.indexes {
+		fieldIndex.remove(replaced, index)
+		fieldIndex.insert(record, index)
+	}
+
+	c.Rows[index] = record
+	c.indexAt(index)
+}
+
+// copy returns a deep copy of the collection
+//
+func (c *Collection) copy() *Collection {
+	rows := copyInterfaceType(c.Rows).([]interface{})
+
+	ids := make(map[string]int, len(c.ids))
+	for key, index := range c.ids {
+		ids[key] = index
+	}
+
+	indexes := make(map[string]*fieldIndex, len(c.indexes))
+	for path := range c.indexes {
+		indexes[path] = newFieldIndex(path, rows)
+	}
+
+	return &Collection{Rows: rows, idField: c.idField, ids: ids, indexes: indexes, maxId: c.maxId}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"testing"
+)
+
+func collectionTestRows(count int) []interface{} {
+	rows := make([]interface{}, count)
+	for i := range rows {
+		rows[i] = map[string]interface{}{"id": int64(i + 1), "title": "Foo"}
+	}
+
+	return rows
+}
+
+func TestCollectionIndex(t *testing.T) {
+	collection := newCollection("posts", collectionTestRows(5))
+
+	for id := int64(1); id <= 5; id++ {
+		if index, ok := collection.indexOf(id); !ok || index != int(id-1) {
+			t.Errorf("Expected ID %d at index %d, got %d (%t)", id, id-1, index, ok)
+		}
+	}
+
+	// String and numeric IDs are interchangeable
+	if index, ok := collection.indexOf("3"); !ok || index != 2 {
+		t.Errorf("Expected ID \"3\" at index 2, got %d (%t)", index, ok)
+	}
+
+	collection.removeAt(1)
+
+	if _, ok := collection.indexOf(int64(2)); ok {
+		t.Error("Deleted record is still indexed")
+	}
+
+	for id := int64(3); id <= 5; id++ {
+		if index, ok := collection.indexOf(id); !ok || index != int(id-2) {
+			t.Errorf("Expected ID %d at index %d after delete, got %d (%t)", id, id-2, index, ok)
+		}
+	}
+
+	collection.add(map[string]interface{}{"id": int64(6)})
+	if index, ok := collection.indexOf(int64(6)); !ok || index != 4 {
+		t.Errorf("Expected ID 6 at index 4, got %d (%t)", index, ok)
+	}
+
+	collection.replaceAt(0, map[string]interface{}{"id": int64(10)})
+	if _, ok := collection.indexOf(int64(1)); ok {
+		t.Error("Replaced ID is still indexed")
+	}
+
+	if index, ok := collection.indexOf(int64(10)); !ok || index != 0 {
+		t.Errorf("Expected ID 10 at index 0, got %d (%t)", index, ok)
+	}
+}
+
+func TestCollectionDuplicateIds(t *testing.T) {
+	rows := []interface{}{
+		map[string]interface{}{"id": int64(1), "title": "First"},
+		map[string]interface{}{"id": int64(1), "title": "Second"},
+		map[string]interface{}{"title": "No ID"},
+	}
+
+	collection := newCollection("posts", rows)
+
+	if index, _ := collection.indexOf(int64(1)); index != 0 {
+		t.Errorf("Expected the first duplicate to be indexed, got index %d", index)
+	}
+
+	// Once the first is deleted, the second takes its place
+	collection.removeAt(0)
+
+	if index, ok := collection.indexOf(int64(1)); !ok || index != 0 {
+		t.Errorf("Expected the remaining duplicate at index 0, got %d (%t)", index, ok)
+	}
+}
+
+func TestCollectionCopy(t *testing.T) {
+	collection := newCollection("posts", collectionTestRows(3))
+	copied := collection.copy()
+
+	copied.removeAt(0)
+	copied.Rows[0].(map[string]interface{})["title"] = "Bar"
+
+	if len(collection.Rows) != 3 {
+		t.Errorf("Expected the original to keep 3 rows, got %d", len(collection.Rows))
+	}
+
+	if index, _ := collection.indexOf(int64(2)); index != 1 {
+		t.Errorf("Expected the original index to be unchanged, got %d", index)
+	}
+
+	if title := collection.Rows[1].(map[string]interface{})["title"]; title != "Foo" {
+		t.Errorf("Expected the original record to be unchanged, got %v", title)
+	}
+}
+
+const benchmarkRecordCount = 1000000
+
+func BenchmarkRecordWithId(b *testing.B) {
+	data := BackingData{"posts": newCollection("posts", collectionTestRows(benchmarkRecordCount))}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		id := int64(i%benchmarkRecordCount + 1)
+
+		if _, err := data.RecordWithId("posts", id); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkInsertRecord(b *testing.B) {
+	defer disableWal()()
+
+	previousData := serverData
+	defer func() {
+		serverData = previousData
+	}()
+
+	serverData = BackingData{"posts": newCollection("posts", collectionTestRows(benchmarkRecordCount))}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		serverData.AddRecord("posts", map[string]interface{}{"id": nextId("posts"), "title": "Foo"})
+	}
+}
+
+// BenchmarkDeleteLastRecord measures the best case of a delete, since no other records move
+//
+func BenchmarkDeleteLastRecord(b *testing.B) {
+	defer disableWal()()
+
+	data := BackingData{"posts": newCollection("posts", collectionTestRows(benchmarkRecordCount+b.N))}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := data.DeleteRecord("posts", int64(benchmarkRecordCount+b.N-i)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkDeleteMiddleRecord measures a typical delete, which moves and re-indexes the half of the records after it
+//
+func BenchmarkDeleteMiddleRecord(b *testing.B) {
+	defer disableWal()()
+
+	data := BackingData{"posts": newCollection("posts", collectionTestRows(benchmarkRecordCount+b.N))}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err := data.DeleteRecord("posts", int64(benchmarkRecordCount/2+i+1)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,576 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// configFileNames are the configuration files looked for in the working directory when none is given
+//
+var configFileNames = []string{"qrest.json", "qrest.yaml", "qrest.yml"}
+
+// Config holds the settings which change how the server behaves. They are read from the configuration file, then the
+// environment and then the command line flags, each overriding the last.
+//
+type Config struct {
+	// DataFile is the JSON file served when none is given on the command line
+	DataFile string
+
+	// ForeignKey names the field which refers to a record in another collection. `{singular}` is replaced by the
+	// singular name of the referenced collection, so "{singular}Id" means comments refer to posts through `postId`.
+	// The shorthands "camel" and "snake" may be used for "{singular}Id" and "{singular}_id".
+	ForeignKey string
+
+	// OnDelete is the policy of relations which don't declare their own. With the default, "none", deleting a record
+	// leaves the records referring to it alone and foreign keys are not validated.
+	OnDelete string
+
+	// Relations declares relations which can't be inferred from the foreign key naming convention, or overrides the
+	// OnDelete policy of inferred ones
+	Relations []Relation
+
+	// PreserveNumbers keeps numbers which can't be represented exactly as an int64 or float64 in their original
+	// form, so that they are written back to the JSON file exactly as they were read
+	PreserveNumbers bool
+
+	// Collections holds the settings of individual collections, keyed by name
+	Collections map[string]CollectionConfig
+
+	// FlushPolicy decides when changes are written back to the JSON file (one of the Flush* policies)
+	FlushPolicy string
+
+	// FlushInterval is how often the file is written with the "interval" policy, and how long the data must go
+	// unchanged before it is written with the "debounce" policy
+	FlushInterval time.Duration
+
+	// Host and Port are the address the server listens on. An empty host listens on every interface.
+	Host string
+	Port string
+
+	// ReadOnly rejects every request which would change the data with 405 Method Not Allowed
+	ReadOnly bool
+
+	// Delay is added to every response, to simulate a slow network
+	Delay time.Duration
+
+	// Prefix is a path (e.g. "/api") every route is served under
+	Prefix string
+
+	// Routes are applied to request paths before they are routed, in order (see RouteRewrite)
+	Routes []RouteRewrite
+
+	// AutoCreate creates a collection when a record is POSTed to a collection which doesn't exist, rather than
+	// responding with 404 Not Found
+	AutoCreate bool
+
+	// Auth holds the credentials requests must have. No credentials are needed if it has none.
+	Auth AuthConfig
+
+	// Watch reloads the JSON file and the configuration file when they are changed by something other than the
+	// server, checking them every WatchInterval
+	Watch         bool
+	WatchInterval time.Duration
+}
+
+// AuthConfig holds the credentials accepted by authMiddleware
+//
+type AuthConfig struct {
+	// Tokens are accepted as `Authorization: Bearer <token>`
+	Tokens []string
+
+	// Users maps the usernames accepted with basic authentication to their passwords
+	Users map[string]string
+
+	// WritesOnly lets requests which don't change the data through without credentials
+	WritesOnly bool
+}
+
+// Enabled returns whether any credentials are configured
+//
+func (auth AuthConfig) Enabled() bool {
+	return len(auth.Tokens) > 0 || len(auth.Users) > 0
+}
+
+// CollectionConfig holds the settings of a single collection
+//
+type CollectionConfig struct {
+	// PrimaryKey is the field holding the ID of each record. Defaults to "id".
+	PrimaryKey string
+
+	// IdStrategy decides how IDs are generated for new records: "autoincrement", "uuid" or "ulid". If empty, it is
+	// inferred from the existing records (see idStrategy).
+	IdStrategy string
+
+	// Indexes lists the (possibly dotted) fields to index, so that equality and range filters on them don't have to
+	// scan the whole collection
+	Indexes []string
+
+	// Schema is checked against every record created or updated, which is rejected with 422 Unprocessable Entity if
+	// it doesn't match. It also applies to singular resources.
+	Schema *Schema
+}
+
+// configFile is the contents of a configuration file. Fields which are left out keep their current value.
+//
+type configFile struct {
+	Data            string                      `json:"data"`
+	Host            string                      `json:"host"`
+	Port            interface{}                 `json:"port"`
+	Prefix          string                      `json:"prefix"`
+	ReadOnly        *bool                       `json:"readOnly"`
+	Delay           string                      `json:"delay"`
+	Routes          string                      `json:"routes"`
+	Flush           string                      `json:"flush"`
+	FlushInterval   string                      `json:"flushInterval"`
+	ForeignKey      string                      `json:"foreignKey"`
+	OnDelete        string                      `json:"onDelete"`
+	PreserveNumbers *bool                       `json:"preserveNumbers"`
+	Relations       []Relation                  `json:"relations"`
+	Collections     map[string]CollectionConfig `json:"collections"`
+	AutoCreate      *bool                       `json:"autoCreate"`
+	Auth            *AuthConfig                 `json:"auth"`
+	Watch           *bool                       `json:"watch"`
+	WatchInterval   string                      `json:"watchInterval"`
+}
+
+var config = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		ForeignKey:    "{singular}Id",
+		OnDelete:      OnDeleteNone,
+		Collections:   make(map[string]CollectionConfig),
+		FlushPolicy:   FlushOnInterval,
+		FlushInterval: 30 * time.Second,
+		Port:          "3000",
+		Watch:         true,
+		WatchInterval: time.Second,
+	}
+}
+
+// loadConfigFromEnv overrides the configuration with any QREST_* environment variables (and PORT and HOST) which
+// are set. Command line flags take precedence over these (see serveCommand).
+//
+func loadConfigFromEnv() {
+	if port := os.Getenv("PORT"); port != "" {
+		config.Port = port
+	}
+
+	if host := os.Getenv("HOST"); host != "" {
+		config.Host = host
+	}
+
+	if prefix := os.Getenv("QREST_PREFIX"); prefix != "" {
+		config.Prefix = normalizePrefix(prefix)
+	}
+
+	if readOnly := os.Getenv("QREST_READ_ONLY"); readOnly != "" {
+		enabled, err := strconv.ParseBool(readOnly)
+		if err != nil {
+			logger.Fatalln("Invalid QREST_READ_ONLY:", err)
+		}
+
+		config.ReadOnly = enabled
+	}
+
+	if autoCreate := os.Getenv("QREST_AUTO_CREATE"); autoCreate != "" {
+		enabled, err := strconv.ParseBool(autoCreate)
+		if err != nil {
+			logger.Fatalln("Invalid QREST_AUTO_CREATE:", err)
+		}
+
+		config.AutoCreate = enabled
+	}
+
+	if delay := os.Getenv("QREST_DELAY"); delay != "" {
+		duration, err := time.ParseDuration(delay)
+		if err != nil || duration < 0 {
+			logger.Fatalf("Invalid QREST_DELAY %s\n", delay)
+		}
+
+		config.Delay = duration
+	}
+
+	if routesFile := os.Getenv("QREST_ROUTES"); routesFile != "" {
+		routes, err := loadRoutesFile(routesFile)
+		if err != nil {
+			logger.Fatalln("Invalid QREST_ROUTES:", err)
+		}
+
+		config.Routes = routes
+	}
+
+	if foreignKey := os.Getenv("QREST_FOREIGN_KEY"); foreignKey != "" {
+		config.ForeignKey = foreignKey
+	}
+
+	if onDelete := os.Getenv("QREST_ON_DELETE"); onDelete != "" {
+		if !validOnDelete(onDelete) {
+			logger.Fatalf("Invalid QREST_ON_DELETE policy %s\n", onDelete)
+		}
+
+		config.OnDelete = onDelete
+	}
+
+	if relationPolicies := os.Getenv("QREST_RELATIONS"); relationPolicies != "" {
+		declared, err := parseRelationPolicies(relationPolicies)
+		if err != nil {
+			logger.Fatalln(err)
+		}
+
+		config.Relations = declared
+	}
+
+	if preserveNumbers := os.Getenv("QREST_PRESERVE_NUMBERS"); preserveNumbers != "" {
+		preserve, err := strconv.ParseBool(preserveNumbers)
+		if err != nil {
+			logger.Fatalln("Invalid QREST_PRESERVE_NUMBERS:", err)
+		}
+
+		config.PreserveNumbers = preserve
+	}
+
+	if watch := os.Getenv("QREST_WATCH"); watch != "" {
+		enabled, err := strconv.ParseBool(watch)
+		if err != nil {
+			logger.Fatalln("Invalid QREST_WATCH:", err)
+		}
+
+		config.Watch = enabled
+	}
+
+	if watchInterval := os.Getenv("QREST_WATCH_INTERVAL"); watchInterval != "" {
+		interval, err := time.ParseDuration(watchInterval)
+		if err != nil || interval <= 0 {
+			logger.Fatalf("Invalid QREST_WATCH_INTERVAL %s\n", watchInterval)
+		}
+
+		config.WatchInterval = interval
+	}
+
+	if flushPolicy := os.Getenv("QREST_FLUSH"); flushPolicy != "" {
+		if !validFlushPolicy(flushPolicy) {
+			logger.Fatalf("Invalid QREST_FLUSH policy %s\n", flushPolicy)
+		}
+
+		config.FlushPolicy = flushPolicy
+	}
+
+	if flushInterval := os.Getenv("QREST_FLUSH_INTERVAL"); flushInterval != "" {
+		interval, err := time.ParseDuration(flushInterval)
+		if err != nil || interval <= 0 {
+			logger.Fatalf("Invalid QREST_FLUSH_INTERVAL %s\n", flushInterval)
+		}
+
+		config.FlushInterval = interval
+	}
+
+	primaryKeys, err := parseAssignments(os.Getenv("QREST_PRIMARY_KEYS"))
+	if err != nil {
+		logger.Fatalln("Invalid QREST_PRIMARY_KEYS:", err)
+	}
+
+	for itemType, key := range primaryKeys {
+		collection := config.Collections[itemType]
+		collection.PrimaryKey = key
+		config.Collections[itemType] = collection
+	}
+
+	strategies, err := parseAssignments(os.Getenv("QREST_ID_STRATEGIES"))
+	if err != nil {
+		logger.Fatalln("Invalid QREST_ID_STRATEGIES:", err)
+	}
+
+	for itemType, strategy := range strategies {
+		if !validIdStrategy(strategy) {
+			logger.Fatalf("Invalid ID strategy %s for %s\n", strategy, itemType)
+		}
+
+		collection := config.Collections[itemType]
+		collection.IdStrategy = strategy
+		config.Collections[itemType] = collection
+	}
+
+	indexes, err := parseIndexes(os.Getenv("QREST_INDEXES"))
+	if err != nil {
+		logger.Fatalln("Invalid QREST_INDEXES:", err)
+	}
+
+	for itemType, paths := range indexes {
+		collection := config.Collections[itemType]
+		collection.Indexes = append(collection.Indexes, paths...)
+		config.Collections[itemType] = collection
+	}
+}
+
+// findConfigFile returns the first of configFileNames which exists in the working directory, or "" if none do
+//
+func findConfigFile() string {
+	for _, fname := range configFileNames {
+		if _, err := os.Stat(fname); err == nil {
+			return fname
+		}
+	}
+
+	return ""
+}
+
+// loadConfigFile overrides the configuration with the settings in a JSON or YAML (if its extension is .yaml or .yml)
+// configuration file. Paths in the file are relative to the directory containing it.
+//
+func loadConfigFile(fname string) error {
+	contents, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+
+	if extension := strings.ToLower(filepath.Ext(fname)); extension == ".yaml" || extension == ".yml" {
+		if contents, err = yamlToJson(contents); err != nil {
+			return fmt.Errorf("%s: %s", fname, err)
+		}
+	}
+
+	file := configFile{}
+
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.UseNumber()
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(&file); err != nil {
+		return fmt.Errorf("%s: %s", fname, err)
+	}
+
+	if err := file.apply(filepath.Dir(fname)); err != nil {
+		return fmt.Errorf("%s: %s", fname, err)
+	}
+
+	return nil
+}
+
+// apply validates the settings of the file and copies them to the configuration. Relative paths are resolved
+// against `dir`.
+//
+func (file configFile) apply(dir string) error {
+	resolve := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+
+		return filepath.Join(dir, path)
+	}
+
+	if file.Data != "" {
+		config.DataFile = resolve(file.Data)
+	}
+
+	if file.Host != "" {
+		config.Host = file.Host
+	}
+
+	if file.Port != nil {
+		config.Port = fmt.Sprint(file.Port)
+	}
+
+	if file.Prefix != "" {
+		config.Prefix = normalizePrefix(file.Prefix)
+	}
+
+	if file.ReadOnly != nil {
+		config.ReadOnly = *file.ReadOnly
+	}
+
+	if file.Delay != "" {
+		delay, err := time.ParseDuration(file.Delay)
+		if err != nil || delay < 0 {
+			return fmt.Errorf("invalid delay %s", file.Delay)
+		}
+
+		config.Delay = delay
+	}
+
+	if file.Routes != "" {
+		routes, err := loadRoutesFile(resolve(file.Routes))
+		if err != nil {
+			return err
+		}
+
+		config.Routes = routes
+	}
+
+	if file.Flush != "" {
+		if !validFlushPolicy(file.Flush) {
+			return fmt.Errorf("invalid flush policy %s", file.Flush)
+		}
+
+		config.FlushPolicy = file.Flush
+	}
+
+	if file.FlushInterval != "" {
+		interval, err := time.ParseDuration(file.FlushInterval)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("invalid flush interval %s", file.FlushInterval)
+		}
+
+		config.FlushInterval = interval
+	}
+
+	if file.ForeignKey != "" {
+		config.ForeignKey = file.ForeignKey
+	}
+
+	if file.OnDelete != "" {
+		if !validOnDelete(file.OnDelete) {
+			return fmt.Errorf("invalid onDelete policy %s", file.OnDelete)
+		}
+
+		config.OnDelete = file.OnDelete
+	}
+
+	if file.PreserveNumbers != nil {
+		config.PreserveNumbers = *file.PreserveNumbers
+	}
+
+	for _, relation := range file.Relations {
+		if relation.Child == "" || relation.ForeignKey == "" || (relation.OnDelete != "" && !validOnDelete(relation.OnDelete)) {
+			return fmt.Errorf("invalid relation %+v", relation)
+		}
+
+		config.Relations = append(config.Relations, relation)
+	}
+
+	for itemType, collection := range file.Collections {
+		if collection.IdStrategy != "" && !validIdStrategy(collection.IdStrategy) {
+			return fmt.Errorf("invalid ID strategy %s for %s", collection.IdStrategy, itemType)
+		}
+
+		if collection.Schema != nil {
+			if err := collection.Schema.compile(); err != nil {
+				return fmt.Errorf("invalid schema for %s: %s", itemType, err)
+			}
+		}
+
+		config.Collections[itemType] = collection
+	}
+
+	if file.AutoCreate != nil {
+		config.AutoCreate = *file.AutoCreate
+	}
+
+	if file.Auth != nil {
+		config.Auth = *file.Auth
+	}
+
+	if file.Watch != nil {
+		config.Watch = *file.Watch
+	}
+
+	if file.WatchInterval != "" {
+		interval, err := time.ParseDuration(file.WatchInterval)
+		if err != nil || interval <= 0 {
+			return fmt.Errorf("invalid watch interval %s", file.WatchInterval)
+		}
+
+		config.WatchInterval = interval
+	}
+
+	return nil
+}
+
+// yamlToJson converts a YAML document to JSON, so that YAML configuration files are decoded exactly like JSON ones
+//
+func yamlToJson(contents []byte) ([]byte, error) {
+	var document interface{}
+	if err := yaml.Unmarshal(contents, &document); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(convertYamlType(document))
+}
+
+// convertYamlType converts the map[interface{}]interface{} values produced by the YAML decoder to
+// map[string]interface{}, which can be encoded as JSON
+//
+func convertYamlType(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(value))
+		for key, value := range value {
+			converted[fmt.Sprint(key)] = convertYamlType(value)
+		}
+
+		return converted
+	case []interface{}:
+		for i, item := range value {
+			value[i] = convertYamlType(item)
+		}
+
+		return value
+	}
+
+	return value
+}
+
+// normalizePrefix gives a route prefix a leading slash and removes any trailing ones, so "api/" becomes "/api" and
+// "/" becomes ""
+//
+func normalizePrefix(prefix string) string {
+	if prefix = strings.Trim(prefix, "/"); prefix == "" {
+		return ""
+	}
+
+	return "/" + prefix
+}
+
+// parseIndexes parses a comma separated list of indexed fields such as "posts.author,posts.meta.lang", where the
+// collection is everything before the first dot
+//
+func parseIndexes(value string) (map[string][]string, error) {
+	indexes := make(map[string][]string)
+
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		dot := strings.Index(field, ".")
+		if dot <= 0 || dot == len(field)-1 {
+			return nil, fmt.Errorf("invalid index %q", field)
+		}
+
+		indexes[field[:dot]] = append(indexes[field[:dot]], field[dot+1:])
+	}
+
+	return indexes, nil
+}
+
+// parseAssignments parses a comma separated list of `key=value` pairs such as "users=_id,articles=slug"
+//
+func parseAssignments(value string) (map[string]string, error) {
+	assignments := make(map[string]string)
+
+	for _, assignment := range strings.Split(value, ",") {
+		assignment = strings.TrimSpace(assignment)
+		if assignment == "" {
+			continue
+		}
+
+		parts := strings.SplitN(assignment, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid assignment %q", assignment)
+		}
+
+		assignments[parts[0]] = parts[1]
+	}
+
+	return assignments, nil
+}
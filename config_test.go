@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// withDefaultConfig resets the configuration for the duration of a test. The returned function restores it.
+//
+func withDefaultConfig() func() {
+	previous := config
+	config = defaultConfig()
+
+	return func() {
+		config = previous
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir := walTestDir(t)
+	defer os.RemoveAll(dir)
+
+	ioutil.WriteFile(filepath.Join(dir, "routes.json"), []byte(`{"/api/*": "/$1"}`), 0644)
+
+	files := map[string]string{
+		"qrest.json": `{
+			"data": "fixtures/db.json",
+			"port": 8080,
+			"prefix": "api/",
+			"delay": "250ms",
+			"routes": "routes.json",
+			"onDelete": "cascade",
+			"relations": [{"child": "posts", "foreignKey": "writer", "parent": "users", "onDelete": "restrict"}],
+			"collections": {
+				"users": {"primaryKey": "_id", "idStrategy": "uuid", "schema": {"required": ["name"]}}
+			},
+			"auth": {"tokens": ["secret"], "writesOnly": true}
+		}`,
+		"qrest.yaml": `
+data: fixtures/db.json
+port: 8080
+prefix: api/
+delay: 250ms
+routes: routes.json
+onDelete: cascade
+relations:
+  - child: posts
+    foreignKey: writer
+    parent: users
+    onDelete: restrict
+collections:
+  users:
+    primaryKey: _id
+    idStrategy: uuid
+    schema:
+      required: [name]
+auth:
+  tokens: [secret]
+  writesOnly: true
+`,
+	}
+
+	for name, contents := range files {
+		func() {
+			defer withDefaultConfig()()
+
+			fname := filepath.Join(dir, name)
+			ioutil.WriteFile(fname, []byte(contents), 0644)
+
+			if err := loadConfigFile(fname); err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+
+			if config.DataFile != filepath.Join(dir, "fixtures", "db.json") {
+				t.Errorf("%s: expected the data file to be relative to the configuration, got %s", name, config.DataFile)
+			}
+
+			if config.Port != "8080" || config.Prefix != "/api" || config.Delay != 250*time.Millisecond {
+				t.Errorf("%s: unexpected port %s, prefix %s or delay %s", name, config.Port, config.Prefix, config.Delay)
+			}
+
+			if len(config.Routes) != 1 || config.Routes[0].From != "/api/*" {
+				t.Errorf("%s: expected the routes file to be loaded, got %v", name, config.Routes)
+			}
+
+			if config.OnDelete != OnDeleteCascade || len(config.Relations) != 1 || config.Relations[0].Parent != "users" {
+				t.Errorf("%s: unexpected relations %s %v", name, config.OnDelete, config.Relations)
+			}
+
+			users := config.Collections["users"]
+			if users.PrimaryKey != "_id" || users.IdStrategy != IdStrategyUUID || users.Schema == nil || len(users.Schema.Required) != 1 {
+				t.Errorf("%s: unexpected collection configuration %+v", name, users)
+			}
+
+			if !config.Auth.Enabled() || !config.Auth.WritesOnly || config.Auth.Tokens[0] != "secret" {
+				t.Errorf("%s: unexpected auth configuration %+v", name, config.Auth)
+			}
+		}()
+	}
+}
+
+func TestLoadConfigFileInvalid(t *testing.T) {
+	defer withDefaultConfig()()
+
+	dir := walTestDir(t)
+	defer os.RemoveAll(dir)
+
+	invalid := []string{
+		`{"prot": 8080}`,
+		`{"delay": "soon"}`,
+		`{"flush": "sometimes"}`,
+		`{"onDelete": "explode"}`,
+		`{"relations": [{"child": "posts"}]}`,
+		`{"collections": {"users": {"idStrategy": "random"}}}`,
+		`{"collections": {"users": {"schema": {"type": "text"}}}}`,
+		`{"routes": "missing.json"}`,
+	}
+
+	fname := filepath.Join(dir, "qrest.json")
+
+	for _, contents := range invalid {
+		ioutil.WriteFile(fname, []byte(contents), 0644)
+
+		if err := loadConfigFile(fname); err == nil {
+			t.Errorf("Expected an error for %s", contents)
+		}
+	}
+}
+
+func TestNormalizePrefix(t *testing.T) {
+	for prefix, expected := range map[string]string{"api": "/api", "/api/": "/api", "/v1/api": "/v1/api", "/": ""} {
+		if normalized := normalizePrefix(prefix); normalized != expected {
+			t.Errorf("Expected %q to be normalized to %q, got %q", prefix, expected, normalized)
+		}
+	}
+}
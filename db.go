@@ -1,71 +1,50 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"io"
 	"io/ioutil"
-	"os"
-	"os/signal"
+	"math/big"
 	"strconv"
 	"sync"
-	"time"
 )
 
 var (
-	serverData    = make(BackingData)
-	dataMutex     sync.RWMutex
-	dirty         = false
-	maxIds        = make(map[string]int64)
-	ErrorNotFound = errors.New("Item not present in data set")
-	JsonFilePath  string
+	serverData         = make(BackingData)
+	dataMutex          sync.RWMutex
+	ErrorNotFound      = errors.New("Item not present in data set")
+	ErrorNotCollection = errors.New("Item is not a collection of records")
+	ErrorNotResource   = errors.New("Item is not a singular resource")
+	ErrorExists        = errors.New("Item already exists")
+	JsonFilePath       string
 )
 
-func init() {
-	if len(os.Args) > 1 {
-		JsonFilePath = os.Args[1]
-	}
-}
-
 type BackingData map[string]interface{}
 
-// recordIndex returns the index of a record within the `BackingData[itemType]` array
+// recordIndex returns the index of a record within the `BackingData[itemType]` array. IDs are compared using
+// idKey, so `id` may be a number or a string.
 //
-func (b BackingData) recordIndex(itemType string, id int64) (int, error) {
-	rows, err := b.ItemType(itemType)
+func (b BackingData) recordIndex(itemType string, id interface{}) (int, error) {
+	collection, err := b.collection(itemType)
 
 	if err != nil {
 		return -1, err
 	}
 
-	for i, row := range rows {
-		rowMap, _ := row.(map[string]interface{})
-
-		currentId, ok := rowMap["id"].(int64)
-
-		if !ok {
-			logger.Errorf("ID either not present for record at index %i or it's unknown type\n", i)
-			continue
-		}
-
-		if err != nil {
-			logger.Errorln(err)
-			continue
-		}
-
-		// Found the item
-		if currentId == id {
-			return i, nil
-		}
+	index, ok := collection.indexOf(id)
+	if !ok {
+		return -1, ErrorNotFound
 	}
 
-	return -1, ErrorNotFound
+	return index, nil
 }
 
 // RecordWithId will return a record with the provided ID. If no such record exists, err
 // will be set to ErrorNotFound
 //
-func (b BackingData) RecordWithId(itemType string, id int64) (map[string]interface{}, error) {
+func (b BackingData) RecordWithId(itemType string, id interface{}) (map[string]interface{}, error) {
 	rows, err := b.ItemType(itemType)
 
 	if err != nil {
@@ -82,30 +61,112 @@ func (b BackingData) RecordWithId(itemType string, id int64) (map[string]interfa
 	return rowMap, nil
 }
 
-func (b BackingData) DeleteRecord(itemType string, id int64) error {
-	records, err := b.ItemType(itemType)
+func (b BackingData) DeleteRecord(itemType string, id interface{}) error {
+	index, err := b.recordIndex(itemType, id)
+	if err != nil {
+		return err
+	}
+
+	return b.deleteRecordAt(itemType, index)
+}
+
+func (b BackingData) deleteRecordAt(itemType string, index int) error {
+	collection, err := b.collection(itemType)
 	if err != nil {
 		return err
 	}
 
+	collection.removeAt(index)
+	wal.append(walEntry{Op: walDelete, Type: itemType, Index: index})
+
+	return nil
+}
+
+// ReplaceRecord swaps the record with the given ID for `record`. Handlers must use this rather than modifying
+// records in place so that the indexes and the write-ahead log stay up to date.
+//
+func (b BackingData) ReplaceRecord(itemType string, id interface{}, record map[string]interface{}) error {
 	index, err := b.recordIndex(itemType, id)
 	if err != nil {
 		return err
 	}
 
-	b[itemType] = append(records[:index], records[index+1:]...)
+	return b.replaceRecordAt(itemType, index, record)
+}
+
+// replaceRecordAt swaps the record at `index` for `record`, for records which can't be found by ID
+//
+func (b BackingData) replaceRecordAt(itemType string, index int, record map[string]interface{}) error {
+	collection, err := b.collection(itemType)
+	if err != nil {
+		return err
+	}
+
+	collection.replaceAt(index, record)
+	wal.append(walEntry{Op: walReplace, Type: itemType, Index: index, Record: record})
 
 	return nil
 }
 
+// ItemType returns the records of a collection. If the item type is a singular resource or some other value,
+// err will be set to ErrorNotCollection
+//
 func (b BackingData) ItemType(itemType string) ([]interface{}, error) {
+	collection, err := b.collection(itemType)
+	if err != nil {
+		return nil, err
+	}
+
+	return collection.Rows, nil
+}
+
+// collection returns the indexed collection for `itemType`. Arrays which haven't been indexed yet (because
+// the data wasn't loaded through parseJsonFile) are indexed on first use, which modifies `b` and so isn't safe
+// for concurrent use.
+//
+func (b BackingData) collection(itemType string) (*Collection, error) {
 	value, ok := b[itemType]
 
 	if !ok {
 		return nil, ErrorNotFound
 	}
 
-	return value.([]interface{}), nil
+	switch value := value.(type) {
+	case *Collection:
+		return value, nil
+	case []interface{}:
+		collection := newCollection(itemType, value)
+		b[itemType] = collection
+
+		return collection, nil
+	}
+
+	return nil, ErrorNotCollection
+}
+
+// Resource returns a singular resource, which is a top level object such as `{"profile": {"name": "Foo"}}`
+// rather than an array of records. If the item type is not an object, err will be set to ErrorNotResource
+//
+func (b BackingData) Resource(name string) (map[string]interface{}, error) {
+	value, ok := b[name]
+
+	if !ok {
+		return nil, ErrorNotFound
+	}
+
+	resource, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, ErrorNotResource
+	}
+
+	return resource, nil
+}
+
+// SetResource replaces the value of a singular resource. `dataMutex` must be write locked by the caller.
+//
+func (b BackingData) SetResource(name string, resource map[string]interface{}) {
+	b[name] = resource
+	wal.append(walEntry{Op: walResource, Type: name, Record: resource})
 }
 
 func (b BackingData) ItemTypes() []string {
@@ -118,11 +179,67 @@ func (b BackingData) ItemTypes() []string {
 	return itemTypes
 }
 
+// AddRecord appends a record to a collection, creating the collection if it doesn't exist. Creating a collection
+// requires `dataMutex` to be write locked.
+//
 func (b BackingData) AddRecord(itemType string, record map[string]interface{}) {
-	items, _ := b.ItemType(itemType)
-	b[itemType] = append(items, record)
+	collection, err := b.collection(itemType)
+	if err != nil {
+		collection = newCollection(itemType, nil)
+		b[itemType] = collection
+	}
+
+	collection.add(record)
+	wal.append(walEntry{Op: walAdd, Type: itemType, Record: record})
+}
+
+// CreateCollection adds a collection holding `rows` (which may be empty). If `name` is already in use, err will be
+// set to ErrorExists. `dataMutex` must be write locked by the caller.
+//
+func (b BackingData) CreateCollection(name string, rows []interface{}) error {
+	if _, ok := b[name]; ok {
+		return ErrorExists
+	}
+
+	if rows == nil {
+		rows = []interface{}{}
+	}
+
+	b[name] = newCollection(name, rows)
+	wal.append(walEntry{Op: walCreate, Type: name, Records: rows})
+
+	return nil
+}
+
+// DropCollection removes a collection and all of its records. If the item type is a singular resource or some other
+// value, err will be set to ErrorNotCollection. `dataMutex` must be write locked by the caller.
+//
+func (b BackingData) DropCollection(name string) error {
+	if _, err := b.collection(name); err != nil {
+		return err
+	}
+
+	delete(b, name)
+	wal.append(walEntry{Op: walDrop, Type: name})
+
+	return nil
+}
+
+// RestoreData replaces the whole data set with a copy of `data`, e.g. a snapshot. The change isn't logged, so the
+// caller must write `data` to the JSON file instead (see restoreData). `dataMutex` must be write locked by the caller.
+//
+func (b BackingData) RestoreData(data BackingData) {
+	for key := range b {
+		delete(b, key)
+	}
+
+	for key, value := range data.Copy() {
+		b[key] = value
+	}
 }
 
+// Copy returns a deep copy of the data, which shares nothing with the original
+//
 func (b BackingData) Copy() BackingData {
 	data := make(BackingData)
 
@@ -135,6 +252,8 @@ func (b BackingData) Copy() BackingData {
 
 func copyInterfaceType(value interface{}) interface{} {
 	switch value.(type) {
+	case *Collection:
+		return value.(*Collection).copy()
 	case map[string]interface{}:
 		mapValue := value.(map[string]interface{})
 
@@ -148,91 +267,104 @@ func copyInterfaceType(value interface{}) interface{} {
 	case []interface{}:
 		valueArray := value.([]interface{})
 		valueArrayCopy := make([]interface{}, len(valueArray))
-		copy(valueArrayCopy, valueArray)
-		for i, value := range valueArrayCopy {
-			valueArray[i] = copyInterfaceType(value)
+		for i, value := range valueArray {
+			valueArrayCopy[i] = copyInterfaceType(value)
 		}
 
 		return valueArrayCopy
-	case json.Number:
-		jsonNumber := value.(json.Number)
-		number, _ := jsonNumber.Int64()
-
-		return json.Number(strconv.FormatInt(number, 10))
 	default:
+		// json.Number is a string, so it and all other scalar values are immutable
 		return value
 	}
 }
 
-// Parses the JSON file provided in the command arguments
+// loadJsonFile reads and decodes the JSON file at `fname`, indexing the arrays in it by ID. The contents of the file
+// are returned as well, so that its checksum can be computed.
 //
-func parseJsonFile(fname string) {
-	file, err := os.Open(fname)
+func loadJsonFile(fname string) (BackingData, []byte, error) {
+	jsonData, err := ioutil.ReadFile(fname)
 	if err != nil {
-		logger.Fatalln(err)
+		return nil, nil, err
 	}
 
-	defer file.Close()
+	data := make(BackingData)
+	if err := decodeJson(bytes.NewReader(jsonData), &data); err != nil {
+		return nil, nil, err
+	}
+
+	return wrapCollections(data), jsonData, nil
+}
+
+// wrapCollections indexes the arrays of freshly decoded data by ID
+//
+func wrapCollections(data BackingData) BackingData {
+	for _, itemType := range data.ItemTypes() {
+		if rows, ok := data[itemType].([]interface{}); ok {
+			data[itemType] = newCollection(itemType, rows)
+		}
+	}
+
+	return data
+}
 
-	err = decodeJson(file, &serverData)
+// Parses the JSON file provided in the command arguments, applies any changes recorded in its write-ahead log since
+// it was last written and opens the log for the changes to come (unless the flush policy is "never"). A copy of the
+// result is kept as `initialData` for `POST /_admin/reset`.
+//
+func parseJsonFile(fname string) {
+	data, jsonData, err := loadJsonFile(fname)
 	if err != nil {
 		logger.Fatalln(err)
 	}
 
-	// Get the highest IDs
-	for _, itemType := range serverData.ItemTypes() {
-		rows, _ := serverData.ItemType(itemType)
-		for _, record := range rows {
-			record := record.(map[string]interface{})
-			id, ok := record["id"].(int64)
+	serverData = data
+	JsonFilePath = fname
+	fileChecksum = dataChecksum(jsonData)
 
-			if !ok {
-				continue
-			}
+	logUnservableItemTypes(serverData)
 
-			if err != nil {
-				continue
-			}
-
-			if max, ok := maxIds[itemType]; id > max || !ok {
-				maxIds[itemType] = id
-			}
-		}
+	// Nothing is written to disk with the "never" policy, so there's no log
+	if config.FlushPolicy == FlushNever {
+		initialData = serverData.Copy()
+		return
 	}
-}
 
-// Flushes the in-memory data to the JSON file
-func flushJson(filename string) {
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	entries, size, err := readWal(walPath(fname), fileChecksum)
+	if err != nil {
+		logger.Fatalln(err)
+	}
 
-	write := func() {
-		if !dirty {
-			return
-		}
+	if err := serverData.replayWal(entries); err != nil {
+		logger.Fatalln(err)
+	}
 
-		dataMutex.RLock()
-		dirty = false
+	if len(entries) > 0 {
+		logger.Infof("Replayed %d changes from %s\n", len(entries), walPath(fname))
+		markDirty()
+	}
 
-		jsonData, err := json.Marshal(serverData)
-		dataMutex.RUnlock()
-		if err != nil {
-			logger.Error(err)
-			return
-		}
+	initialData = serverData.Copy()
 
-		ioutil.WriteFile(filename, jsonData, 0755)
+	wal, err = openWal(walPath(fname), fileChecksum, size)
+	if err != nil {
+		logger.Fatalln(err)
 	}
+}
 
-	// Flush loop
-	for {
-		select {
-		case <-c:
-			write()
-			return
-		// Flush every 30 seconds
-		case <-time.After(30 * time.Second):
-			write()
+// logUnservableItemTypes logs an error for every top level value of freshly loaded data which won't be served: only
+// arrays of records and objects can be, and only under names which don't clash with other routes
+//
+func logUnservableItemTypes(data BackingData) {
+	for _, itemType := range data.ItemTypes() {
+		if !validCollectionName(itemType) {
+			logger.Errorf("Ignoring %q: the name is reserved or can't be used in a route\n", itemType)
+			continue
+		}
+
+		switch data[itemType].(type) {
+		case *Collection, map[string]interface{}:
+		default:
+			logger.Errorf("Ignoring %q: top level values must be an array of records or an object, got %#v\n", itemType, data[itemType])
 		}
 	}
 }
@@ -249,7 +381,7 @@ func decodeJson(r io.Reader, data interface{}) error {
 		return err
 	}
 
-	// Convert all json.Number to int64
+	// Convert all json.Number to int64 or float64 (see convertNumber)
 	if dataMap, ok := data.(*map[string]interface{}); ok {
 		convertMapNumbers(*dataMap)
 	}
@@ -284,12 +416,36 @@ func convertMapType(value interface{}) interface{} {
 
 		return valueArray
 	case json.Number:
-		number := value.(json.Number)
-		numberAsInt, _ := number.Int64()
-
-		return numberAsInt
+		return convertNumber(value.(json.Number))
 	default:
 		return value
 	}
 
 }
+
+// convertNumber converts a decoded number to int64 if it is an integer which fits, and float64 otherwise. If
+// `config.PreserveNumbers` is set, numbers which can't be represented exactly by either (such as very large
+// integers or decimals with more digits than a float64 holds) are kept as json.Number so that they are written
+// back out verbatim. Numbers outside of the range of a float64 are always kept.
+//
+func convertNumber(number json.Number) interface{} {
+	if integer, err := number.Int64(); err == nil {
+		return integer
+	}
+
+	float, err := number.Float64()
+	if err != nil {
+		return number
+	}
+
+	if config.PreserveNumbers {
+		exact, _ := new(big.Rat).SetString(number.String())
+		converted, _ := new(big.Rat).SetString(strconv.FormatFloat(float, 'g', -1, 64))
+
+		if exact == nil || converted == nil || exact.Cmp(converted) != 0 {
+			return number
+		}
+	}
+
+	return float
+}
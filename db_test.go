@@ -32,14 +32,37 @@ const jsonTestData = `
       "body": "Testing Comment ID 2",
       "postId": 2
     }
+  ],
+  "profile": {
+    "name": "Foo"
+  },
+  "tags": [
+    {
+      "id": "abc-123",
+      "name": "Foo"
+    }
   ]
 }
 `
 
+// maxId returns the highest ID generated for (or loaded into) a collection of the test server
+//
+func maxId(itemType string) int64 {
+	unlock := lockCollections(nil, []string{itemType})
+	defer unlock()
+
+	collection, err := serverData.collection(itemType)
+	if err != nil {
+		return 0
+	}
+
+	return collection.maxId
+}
+
 func TestParseJsonFile(t *testing.T) {
 	// `TestMain` will have already called parseJsonFile for the initial setup,
 	// so this is just a quick check to make sure that actually succeeded
-	if maxIds["posts"] != 2 || maxIds["comments"] != 2 {
+	if maxId("posts") != 2 || maxId("comments") != 2 {
 		t.Fatal("Failing TestParseJsonFile fails all tests")
 	}
 }
@@ -47,7 +70,7 @@ func TestParseJsonFile(t *testing.T) {
 func TestItemTypes(t *testing.T) {
 	itemTypes := serverData.ItemTypes()
 	itemTypesFound := make(map[string]bool)
-	expectedTypes := []string{"posts", "comments"}
+	expectedTypes := []string{"posts", "comments", "profile", "tags"}
 
 	if len(itemTypes) != len(expectedTypes) {
 		t.Errorf("Expected %d item types, got %d\n", len(expectedTypes), len(itemTypes))
@@ -164,6 +187,101 @@ func TestJsonDecode(t *testing.T) {
 	}
 }
 
+func TestItemTypeKinds(t *testing.T) {
+	data := BackingData{
+		"posts":   []interface{}{},
+		"profile": map[string]interface{}{"name": "Foo"},
+		"version": int64(1),
+	}
+
+	if _, err := data.ItemType("profile"); err != ErrorNotCollection {
+		t.Errorf("Expected ErrorNotCollection, got %v", err)
+	}
+
+	if _, err := data.ItemType("version"); err != ErrorNotCollection {
+		t.Errorf("Expected ErrorNotCollection, got %v", err)
+	}
+
+	if _, err := data.Resource("posts"); err != ErrorNotResource {
+		t.Errorf("Expected ErrorNotResource, got %v", err)
+	}
+
+	if _, err := data.Resource("missing"); err != ErrorNotFound {
+		t.Errorf("Expected ErrorNotFound, got %v", err)
+	}
+
+	if resource, err := data.Resource("profile"); err != nil || resource["name"] != "Foo" {
+		t.Errorf("Unexpected resource %#v (error %v)", resource, err)
+	}
+}
+
+func TestJsonDecodePrecision(t *testing.T) {
+	defer withDefaultConfig()()
+
+	input := `{"price": 19.99, "count": 3, "big": 12345678901234567890, "precise": 0.10000000000000000001, "exp": 1e3, "nested": [1.5]}`
+
+	tests := map[bool]map[string]interface{}{
+		false: map[string]interface{}{
+			"price":   19.99,
+			"count":   int64(3),
+			"big":     float64(12345678901234567890),
+			"precise": 0.1,
+			"exp":     float64(1000),
+			"nested":  []interface{}{1.5},
+		},
+		true: map[string]interface{}{
+			"price":   19.99,
+			"count":   int64(3),
+			"big":     json.Number("12345678901234567890"),
+			"precise": json.Number("0.10000000000000000001"),
+			"exp":     float64(1000),
+			"nested":  []interface{}{1.5},
+		},
+	}
+
+	for preserve, expected := range tests {
+		config.PreserveNumbers = preserve
+		data := make(map[string]interface{})
+
+		if err := decodeJson(strings.NewReader(input), &data); err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(data, expected) {
+			t.Errorf("PreserveNumbers %t: expected %#v, got %#v", preserve, expected, data)
+		}
+	}
+
+	// Preserved numbers are written back out verbatim
+	config.PreserveNumbers = true
+	data := make(map[string]interface{})
+	decodeJson(strings.NewReader(`{"big":12345678901234567890,"price":19.99}`), &data)
+
+	if jsonData, _ := json.Marshal(data); string(jsonData) != `{"big":12345678901234567890,"price":19.99}` {
+		t.Errorf("Unexpected round trip %s", jsonData)
+	}
+}
+
 // TODO: Need to add tests for db. Most of the functionality will also be covered by the handlers, but there
 // should also be isolated tests
 //
+
+func TestCopyIsDeep(t *testing.T) {
+	data := BackingData{
+		"posts":   newCollection("posts", []interface{}{map[string]interface{}{"id": int64(1), "tags": []interface{}{"a"}}}),
+		"profile": map[string]interface{}{"links": []interface{}{map[string]interface{}{"url": "a"}}},
+	}
+
+	copied := data.Copy()
+
+	copied["posts"].(*Collection).Rows[0].(map[string]interface{})["tags"].([]interface{})[0] = "b"
+	copied["profile"].(map[string]interface{})["links"].([]interface{})[0].(map[string]interface{})["url"] = "b"
+
+	if tag := data["posts"].(*Collection).Rows[0].(map[string]interface{})["tags"].([]interface{})[0]; tag != "a" {
+		t.Errorf("Expected the original record to be unchanged, got %v", tag)
+	}
+
+	if url := data["profile"].(map[string]interface{})["links"].([]interface{})[0].(map[string]interface{})["url"]; url != "a" {
+		t.Errorf("Expected the original resource to be unchanged, got %v", url)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Policies deciding when the in-memory data is written back to the JSON file
+//
+const (
+	// FlushWriteThrough writes the file at the end of every request which changed the data
+	FlushWriteThrough = "write-through"
+	// FlushOnInterval writes the file every `config.FlushInterval` if the data changed
+	FlushOnInterval = "interval"
+	// FlushDebounce writes the file once the data hasn't changed for `config.FlushInterval`
+	FlushDebounce = "debounce"
+	// FlushNever keeps all changes in memory. Neither the file nor the write-ahead log are written to, except by
+	// an explicit `POST /_admin/flush`.
+	FlushNever = "never"
+)
+
+var (
+	// dirty is set (to 1) when the data has changed since it was last flushed. It is only accessed atomically.
+	dirty int32
+
+	// dataChanged receives a value when the data changes, for the debounce policy
+	dataChanged = make(chan struct{}, 1)
+
+	// flushMutex prevents two flushes from writing the file at once
+	flushMutex sync.Mutex
+)
+
+// validFlushPolicy returns whether `policy` is one of the Flush* policies
+//
+func validFlushPolicy(policy string) bool {
+	switch policy {
+	case FlushWriteThrough, FlushOnInterval, FlushDebounce, FlushNever:
+		return true
+	}
+
+	return false
+}
+
+// markDirty records that the data has changed since it was last flushed
+//
+func markDirty() {
+	atomic.StoreInt32(&dirty, 1)
+
+	select {
+	case dataChanged <- struct{}{}:
+	default:
+	}
+}
+
+// flushData writes the in-memory data to the JSON file if it changed since it was last written (or always, if
+// `force` is set). Once the file is written, the write-ahead log is reset since the file holds every change.
+//
+func flushData(filename string, force bool) error {
+	flushMutex.Lock()
+	defer flushMutex.Unlock()
+
+	if atomic.SwapInt32(&dirty, 0) == 0 && !force {
+		return nil
+	}
+
+	// Nothing may change until the log is reset, otherwise those changes would be lost
+	unlock := lockAllCollections()
+	defer unlock()
+
+	return writeSnapshot(filename, serverData)
+}
+
+// writeSnapshot writes `data` to the JSON file at `filename` and resets the write-ahead log, since the file holds
+// every change. `flushMutex` must be locked by the caller, and the data may not change until it returns.
+//
+func writeSnapshot(filename string, data BackingData) error {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	// The new file is durable once this returns (including the rename), so the log can be reset safely
+	if err := writeFileAtomically(filename, jsonData); err != nil {
+		markDirty()
+		return err
+	}
+
+	fileChecksum = dataChecksum(jsonData)
+
+	return wal.reset(fileChecksum)
+}
+
+// Flushes the in-memory data to the JSON file according to `policy` (one of the Flush* policies) until `stop` is
+// closed, and then one final time. The error of the final flush is returned.
+//
+func flushJson(filename string, policy string, flushInterval time.Duration, stop <-chan struct{}) error {
+	write := func() {
+		if err := flushData(filename, false); err != nil {
+			logger.Error(err)
+		}
+	}
+
+	var interval <-chan time.Time
+	if policy == FlushOnInterval {
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+
+		interval = ticker.C
+	}
+
+	var debounce <-chan time.Time
+
+	// Flush loop
+	for {
+		select {
+		case <-stop:
+			if policy == FlushNever {
+				return nil
+			}
+
+			return flushData(filename, false)
+		case <-interval:
+			write()
+		case <-dataChanged:
+			if policy == FlushDebounce {
+				debounce = time.After(flushInterval)
+			}
+		case <-debounce:
+			debounce = nil
+			write()
+		}
+	}
+}
+
+// writeThroughMiddleware flushes the data at the end of every request when the write-through policy is in use. The
+// flush happens before the response is sent (unless the response was already flushed to the client by the handler).
+//
+func writeThroughMiddleware(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	next(w, r)
+
+	if config.FlushPolicy == FlushWriteThrough {
+		if err := flushData(JsonFilePath, false); err != nil {
+			logger.Error(err)
+		}
+	}
+}
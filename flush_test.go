@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFlushData(t *testing.T) {
+	dir := walTestDir(t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "db.json")
+	ioutil.WriteFile(filename, []byte(`{}`), 0644)
+
+	previousData, previousWal := serverData, wal
+	defer func() {
+		serverData, wal = previousData, previousWal
+	}()
+
+	log, err := openWal(walPath(filename), dataChecksum([]byte(`{}`)), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer log.Close()
+
+	serverData = BackingData{"posts": []interface{}{}}
+	wal = log
+
+	serverData.AddRecord("posts", map[string]interface{}{"id": int64(1)})
+	markDirty()
+
+	if err := flushData(filename, false); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, _ := ioutil.ReadFile(filename)
+	if string(contents) != `{"posts":[{"id":1}]}` {
+		t.Errorf("Unexpected contents %s", contents)
+	}
+
+	// The file holds every change, so the log is empty
+	if entries, _, _ := readWal(walPath(filename), dataChecksum(contents)); len(entries) != 0 {
+		t.Errorf("Expected the log to be reset, got %d entries", len(entries))
+	}
+
+	// Nothing changed, so the file isn't written unless the flush is forced
+	ioutil.WriteFile(filename, []byte(`{}`), 0644)
+
+	if err := flushData(filename, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if contents, _ := ioutil.ReadFile(filename); string(contents) != `{}` {
+		t.Errorf("Expected the file not to be written, got %s", contents)
+	}
+
+	if err := flushData(filename, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if contents, _ := ioutil.ReadFile(filename); string(contents) != `{"posts":[{"id":1}]}` {
+		t.Errorf("Expected a forced flush to write the file, got %s", contents)
+	}
+}
+
+func TestRestoreDataWritesSnapshot(t *testing.T) {
+	dir := walTestDir(t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "db.json")
+	ioutil.WriteFile(filename, []byte(`{}`), 0644)
+
+	previousData, previousWal, previousPath, previousChecksum := serverData, wal, JsonFilePath, fileChecksum
+	defer func() {
+		serverData, wal, JsonFilePath, fileChecksum = previousData, previousWal, previousPath, previousChecksum
+	}()
+
+	log, err := openWal(walPath(filename), dataChecksum([]byte(`{}`)), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer log.Close()
+
+	serverData = BackingData{"posts": []interface{}{}}
+	wal = log
+	JsonFilePath = filename
+
+	serverData.AddRecord("posts", map[string]interface{}{"id": int64(1)})
+
+	snapshot := BackingData{"posts": newCollection("posts", []interface{}{map[string]interface{}{"id": int64(2)}})}
+	if err := restoreData(httptest.NewRequest("POST", "/_admin/reset", nil), snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	// The restored data is written as a snapshot rather than appended to the log
+	contents, _ := ioutil.ReadFile(filename)
+	if string(contents) != `{"posts":[{"id":2}]}` {
+		t.Errorf("Unexpected contents %s", contents)
+	}
+
+	if entries, _, _ := readWal(walPath(filename), dataChecksum(contents)); len(entries) != 0 {
+		t.Errorf("Expected the log to be reset, got %d entries", len(entries))
+	}
+
+	if _, err := serverData.RecordWithId("posts", int64(2)); err != nil {
+		t.Errorf("Expected the snapshot to be restored: %s", err)
+	}
+
+	// If the snapshot can't be written, the data is left alone
+	JsonFilePath = filepath.Join(dir, "missing", "db.json")
+
+	if err := restoreData(httptest.NewRequest("POST", "/_admin/reset", nil), BackingData{}); err == nil {
+		t.Error("Expected an error for a missing directory")
+	}
+
+	if _, err := serverData.RecordWithId("posts", int64(2)); err != nil {
+		t.Errorf("Expected the data to be unchanged: %s", err)
+	}
+}
+
+func TestValidFlushPolicy(t *testing.T) {
+	for _, policy := range []string{FlushWriteThrough, FlushOnInterval, FlushDebounce, FlushNever} {
+		if !validFlushPolicy(policy) {
+			t.Errorf("Expected %s to be valid", policy)
+		}
+	}
+
+	if validFlushPolicy("sometimes") {
+		t.Error("Expected an unknown policy to be invalid")
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"net/http"
 
 	"fmt"
-	"strconv"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -20,20 +19,45 @@ import (
 // The following routes will be created:
 //
 //    POST /posts (creates a new post record)
-//    GET /posts (returns all post records)
+//    GET /posts (returns all post records, optionally filtered by the query string e.g. ?author=Foo)
 //    GET /posts/:id (returns a specific record)
 //    PUT /posts/:id (creates or updates a record with the specified ID)
 //    PATCH /posts/:id (updates a record with the specified ID)
 //    DELETE /posts/:id (deletes the specified record)
 //
+// If records of one collection refer to another through a foreign key (e.g. comments with a `postId`), nested
+// routes are also created:
+//
+//    GET /posts/:id/comments (returns the comments belonging to a post)
+//    POST /posts/:id/comments (creates a comment belonging to a post)
+//
+// Keys holding an object rather than an array (e.g. `"profile": { "name": "Foo" }`) are singular resources. See
+// addResourceRoutes.
+//
 //
 func addDynamicRoutes(router *httprouter.Router) {
+	relations = buildRelations(serverData)
+
 	// set up our routes
 	for _, itemType := range serverData.ItemTypes() {
 		// Shadow these variables. If this isn't done, then the closures below will see
 		// `value` and `key` as whatever they were in the last(?) iteration of the above for loop
 		itemType := itemType
 
+		// Names such as `_admin` or `db` would clash with other routes
+		if !validCollectionName(itemType) {
+			continue
+		}
+
+		// Objects are singular resources, anything else which isn't an array can't be served
+		if _, err := serverData.ItemType(itemType); err != nil {
+			if _, err := serverData.Resource(itemType); err == nil {
+				addResourceRoutes(router, itemType)
+			}
+
+			continue
+		}
+
 		// POST /type
 		router.POST(fmt.Sprintf("/%s", itemType), func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 			data, err := readRequestData(r)
@@ -42,31 +66,36 @@ func addDynamicRoutes(router *httprouter.Router) {
 				return
 			}
 
-			dataMutex.Lock()
+			unlock := lockCollections([]string{itemType}, referencedCollections(itemType))
+			defer unlock()
 
-			// The idea with grabbing the record with ID 1 is to see if any records even exist. If none exist, the loop
-			// should not execute at all, giving the first record id 1
-			id := int64(1)
-			_, err = serverData.RecordWithId(itemType, id)
-			for id = maxIds[itemType]; err != ErrorNotFound; _, err = serverData.RecordWithId(itemType, id) {
-				id++
+			if err := serverData.ValidateRecord(itemType, data); err != nil {
+				errorJsonResponse(w, r, http.StatusUnprocessableEntity, err)
+				return
 			}
 
-			data["id"] = id
-
-			dirty = true
-			serverData.AddRecord(itemType, data)
-
-			maxIds[itemType] = id
+			insertRecord(itemType, data)
 
-			dataMutex.Unlock()
-
-			w.WriteHeader(http.StatusCreated)
+			statusJsonResponse(w, r, http.StatusCreated, data)
 		})
 
 		// GET /type
 		router.GET(fmt.Sprintf("/%s", itemType), func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-			items, _ := serverData.ItemType(itemType)
+			unlock := lockForQuery(r, itemType)
+			defer unlock()
+
+			collection, err := serverData.collection(itemType)
+			if err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			items, err := queryRecords(w, r, itemType, collection.filterCandidates(r.URL.Query()))
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
 			genericJsonResponse(w, r, items)
 		})
 
@@ -74,7 +103,38 @@ func addDynamicRoutes(router *httprouter.Router) {
 		for _, method := range []string{"GET", "PATCH", "PUT", "DELETE"} {
 			method := method
 			router.Handle(method, fmt.Sprintf("/%s/:id", itemType), func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-				idParam, _ := strconv.ParseInt(ps.ByName("id"), 10, 64)
+				// The body is read before locking, so that a slow upload doesn't hold up requests to the collection
+				var (
+					requestData map[string]interface{}
+					patch       patchDocument
+					err         error
+				)
+
+				switch method {
+				case "PUT":
+					requestData, err = readRequestData(r)
+				case "PATCH":
+					patch, err = readPatchDocument(r)
+				}
+
+				if err != nil {
+					errorJsonResponse(w, r, http.StatusBadRequest, err)
+					return
+				}
+
+				switch method {
+				case "GET":
+					unlock := lockForQuery(r, itemType)
+					defer unlock()
+				case "DELETE":
+					unlock := lockCollections(cascadeCollections(itemType), nil)
+					defer unlock()
+				default:
+					unlock := lockCollections([]string{itemType}, referencedCollections(itemType))
+					defer unlock()
+				}
+
+				idParam := parseIdParam(itemType, ps.ByName("id"))
 
 				record, err := serverData.RecordWithId(itemType, idParam)
 
@@ -82,24 +142,22 @@ func addDynamicRoutes(router *httprouter.Router) {
 					if err == ErrorNotFound {
 						// If it's not found, then this request acts as a POST
 						if method == "PUT" {
-							newData, err := readRequestData(r)
-							if err != nil {
-								w.WriteHeader(http.StatusBadRequest)
-								return
+							newData := requestData
+
+							if _, hasId := newData[primaryKey(itemType)]; !hasId {
+								newData[primaryKey(itemType)] = idParam
 							}
 
-							if _, hasId := newData["id"]; !hasId {
-								newData["id"] = idParam
+							if err := serverData.ValidateRecord(itemType, newData); err != nil {
+								errorJsonResponse(w, r, http.StatusUnprocessableEntity, err)
+								return
 							}
 
-							dataMutex.Lock()
-							dirty = true
+							markDirty()
 
 							serverData.AddRecord(itemType, newData)
 
-							dataMutex.Unlock()
-
-							w.WriteHeader(http.StatusCreated)
+							statusJsonResponse(w, r, http.StatusCreated, newData)
 						} else {
 							w.WriteHeader(http.StatusNotFound)
 						}
@@ -113,79 +171,263 @@ func addDynamicRoutes(router *httprouter.Router) {
 				// The method type determines how we respond
 				switch method {
 				case "GET":
-					genericJsonResponse(w, r, record)
-					return
-				case "PATCH":
-					updatedData, err := readRequestData(r)
+					query := r.URL.Query()
+
+					record, err := parseRelationQuery(query).Apply(serverData, itemType, record)
 					if err != nil {
 						w.WriteHeader(http.StatusBadRequest)
 						return
 					}
 
-					dataMutex.Lock()
-					for key, value := range updatedData {
-						record[key] = value
-					}
-
-					dirty = true
-
-					dataMutex.Unlock()
-
+					genericJsonResponse(w, r, parseProjection(query).Apply(record))
 					return
-				case "PUT":
-					updatedData, err := readRequestData(r)
+				case "PATCH":
+					patched, err := applyPatch(patch, record)
 					if err != nil {
-						w.WriteHeader(http.StatusBadRequest)
+						errorJsonResponse(w, r, patchErrorStatus(err), err)
 						return
 					}
 
-					dataMutex.Lock()
+					// The ID can't be changed or removed by the patch
+					patched[primaryKey(itemType)] = record[primaryKey(itemType)]
 
-					for key, _ := range record {
-						record[key] = nil
+					if err := serverData.ValidateRecord(itemType, patched); err != nil {
+						errorJsonResponse(w, r, http.StatusUnprocessableEntity, err)
+						return
 					}
 
-					for key, value := range updatedData {
-						record[key] = value
-					}
+					serverData.ReplaceRecord(itemType, idParam, patched)
+					markDirty()
 
-					dirty = true
+					genericJsonResponse(w, r, patched)
+					return
+				case "PUT":
+					updatedData := requestData
 
-					dataMutex.Unlock()
+					// The record is replaced by the request body, except for its ID
+					updatedData[primaryKey(itemType)] = record[primaryKey(itemType)]
 
-					w.WriteHeader(http.StatusOK)
-					return
-				case "DELETE":
-					dataMutex.Lock()
+					if err := serverData.ValidateRecord(itemType, updatedData); err != nil {
+						errorJsonResponse(w, r, http.StatusUnprocessableEntity, err)
+						return
+					}
 
-					dirty = true
-					serverData.DeleteRecord(itemType, idParam)
+					serverData.ReplaceRecord(itemType, idParam, updatedData)
+					markDirty()
 
-					dataMutex.Unlock()
+					genericJsonResponse(w, r, updatedData)
+					return
+				case "DELETE":
+					err := serverData.DeleteRecordWithRelations(itemType, idParam)
+					switch err {
+					case nil:
+						markDirty()
+						w.WriteHeader(http.StatusOK)
+					case ErrorConflict:
+						errorJsonResponse(w, r, http.StatusConflict, err)
+					case ErrorNotFound:
+						w.WriteHeader(http.StatusNotFound)
+					default:
+						w.WriteHeader(http.StatusInternalServerError)
+					}
 
-					w.WriteHeader(http.StatusOK)
 					return
 				}
 			})
 		}
 	}
+
+	// Nested routes for related collections
+	for _, relation := range relations {
+		relation := relation
+		path := fmt.Sprintf("/%s/:id/%s", relation.Parent, relation.Child)
+
+		if !validCollectionName(relation.Parent) || !validCollectionName(relation.Child) {
+			continue
+		}
+
+		// GET /parent/id/child
+		router.GET(path, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			unlock := lockForQuery(r, relation.Parent, relation.Child)
+			defer unlock()
+
+			parent, err := serverData.RecordWithId(relation.Parent, ps.ByName("id"))
+			if err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			children := make([]interface{}, 0)
+			for _, child := range serverData.referencingRecords(relation, parent[primaryKey(relation.Parent)]) {
+				children = append(children, child)
+			}
+
+			children, err = queryRecords(w, r, relation.Child, children)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			genericJsonResponse(w, r, children)
+		})
+
+		// POST /parent/id/child
+		router.POST(path, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+			data, err := readRequestData(r)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			unlock := lockCollections([]string{relation.Child}, referencedCollections(relation.Child))
+			defer unlock()
+
+			parent, err := serverData.RecordWithId(relation.Parent, ps.ByName("id"))
+			if err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+
+			data[relation.ForeignKey] = parent[primaryKey(relation.Parent)]
+
+			if err := serverData.ValidateRecord(relation.Child, data); err != nil {
+				errorJsonResponse(w, r, http.StatusUnprocessableEntity, err)
+				return
+			}
+
+			insertRecord(relation.Child, data)
+
+			statusJsonResponse(w, r, http.StatusCreated, data)
+		})
+	}
+}
+
+// addResourceRoutes adds the routes for a singular resource, which is a top level object rather than an array
+// of records:
+//
+//    GET /profile (returns the object)
+//    PUT /profile (replaces the object)
+//    PATCH /profile (updates the fields present in the request)
+//
+//
+func addResourceRoutes(router *httprouter.Router, name string) {
+	path := fmt.Sprintf("/%s", name)
+
+	// GET /resource
+	router.GET(path, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		dataMutex.RLock()
+		defer dataMutex.RUnlock()
+
+		resource, err := serverData.Resource(name)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		genericJsonResponse(w, r, parseProjection(r.URL.Query()).Apply(resource))
+	})
+
+	// PUT /resource
+	router.PUT(path, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		data, err := readRequestData(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		dataMutex.Lock()
+		defer dataMutex.Unlock()
+
+		if err := validateSchema(name, data); err != nil {
+			errorJsonResponse(w, r, http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		serverData.SetResource(name, data)
+		markDirty()
+
+		genericJsonResponse(w, r, data)
+	})
+
+	// PATCH /resource
+	router.PATCH(path, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		patch, err := readPatchDocument(r)
+		if err != nil {
+			errorJsonResponse(w, r, http.StatusBadRequest, err)
+			return
+		}
+
+		dataMutex.Lock()
+		defer dataMutex.Unlock()
+
+		resource, err := serverData.Resource(name)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		patched, err := applyPatch(patch, resource)
+		if err != nil {
+			errorJsonResponse(w, r, patchErrorStatus(err), err)
+			return
+		}
+
+		if err := validateSchema(name, patched); err != nil {
+			errorJsonResponse(w, r, http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		serverData.SetResource(name, patched)
+		markDirty()
+
+		genericJsonResponse(w, r, patched)
+	})
+}
+
+// insertRecord gives `record` a new ID (see nextId) and adds it to the data set. The collection must be write locked
+// by the caller (see lockCollections).
+//
+func insertRecord(itemType string, record map[string]interface{}) {
+	record[primaryKey(itemType)] = nextId(itemType)
+
+	markDirty()
+	serverData.AddRecord(itemType, record)
 }
 
 // addStaticRoutes adds all routes which are present regardless of the JSON file's data. These include
 //
 //    GET /db (returns the entire DB as a JSON structure)
+//    POST /_admin/flush (writes the data to the JSON file before responding)
 //
 //
 func addStaticRoutes(router *httprouter.Router) {
 	router.GET("/db", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		unlock := lockAllCollections()
+		defer unlock()
+
 		genericJsonResponse(w, r, serverData)
 	})
+
+	router.POST("/_admin/flush", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if err := flushData(JsonFilePath, true); err != nil {
+			errorJsonResponse(w, r, http.StatusInternalServerError, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	})
 }
 
 // genericJsonResponse writes a generic JSON response and handles any errors which may occur
 // when marshalling the data
 //
 func genericJsonResponse(w http.ResponseWriter, r *http.Request, data interface{}) {
+	statusJsonResponse(w, r, http.StatusOK, data)
+}
+
+// statusJsonResponse writes a JSON response with the given status, e.g. 201 Created along with the created record
+//
+func statusJsonResponse(w http.ResponseWriter, r *http.Request, status int, data interface{}) {
 	jsonData, err := json.Marshal(data)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -193,6 +435,17 @@ func genericJsonResponse(w http.ResponseWriter, r *http.Request, data interface{
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(jsonData)
+}
+
+// errorJsonResponse writes `status` along with a JSON body describing `err`
+//
+func errorJsonResponse(w http.ResponseWriter, r *http.Request, status int, err error) {
+	jsonData, _ := json.Marshal(map[string]string{"error": err.Error()})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	w.Write(jsonData)
 }
 
@@ -7,9 +7,14 @@ import (
 	"strings"
 	"testing"
 	"io"
+	"io/ioutil"
 	"encoding/json"
 	"bytes"
 	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
 )
 
 func TestGetAllRecordsOfType(t *testing.T) {
@@ -170,7 +175,7 @@ func TestPostRecord(t *testing.T) {
 				return
 			}
 
-			test["id"] = maxIds[recordType]
+			test["id"] = maxId(recordType)
 
 			testAsJson, err = json.Marshal(test)
 			if err != nil {
@@ -178,7 +183,7 @@ func TestPostRecord(t *testing.T) {
 				continue
 			}
 
-			err = testGetRequest(fmt.Sprintf("/%s/%d", recordType, maxIds[recordType]), string(testAsJson), http.StatusOK, false, true)
+			err = testGetRequest(fmt.Sprintf("/%s/%d", recordType, maxId(recordType)), string(testAsJson), http.StatusOK, false, true)
 			if err != nil {
 				t.Error(err)
 			}
@@ -399,3 +404,842 @@ func jsonResponseMatchesActual(resp *http.Response, expected string, useArray bo
 
 	return reflect.DeepEqual(expectedData, actualData), nil, expectedData, actualData
 }
+
+func TestGetFilteredRecords(t *testing.T) {
+	paths := map[string]string{
+		"/posts?author=Bar": `[
+					{
+					  "id": 2,
+					  "title": "Testing Post ID 2",
+					  "author": "Bar"
+					}
+				  ]`,
+		"/comments?postId=1&body=Testing": `[
+						{
+						  "id": 1,
+						  "body": "Testing",
+						  "postId": 1
+						}
+					  ]`,
+		"/posts?author=Foo&author=Bar": `[
+					{
+					  "id": 1,
+					  "title": "Testing",
+					  "author": "Foo"
+					},
+					{
+					  "id": 2,
+					  "title": "Testing Post ID 2",
+					  "author": "Bar"
+					}
+				  ]`,
+		"/posts?author=Nobody": `[]`,
+		"/comments?postId=abc": `[]`,
+		"/comments?postId_gt=1&body_like=Comment": `[
+						{
+						  "id": 2,
+						  "body": "Testing Comment ID 2",
+						  "postId": 2
+						}
+					  ]`,
+	}
+
+	for path, expectedJson := range paths {
+		err := testGetRequest(path, expectedJson, http.StatusOK, true, true)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestGetInvalidFilter(t *testing.T) {
+	err := testGetRequest("/posts?title_like=(", "", http.StatusBadRequest, true, false)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetSortedRecords(t *testing.T) {
+	expectedJson := `[
+		{
+		  "id": 2,
+		  "body": "Testing Comment ID 2",
+		  "postId": 2
+		},
+		{
+		  "id": 1,
+		  "body": "Testing",
+		  "postId": 1
+		}
+	  ]`
+
+	err := testGetRequest("/comments?_sort=-postId", expectedJson, http.StatusOK, true, true)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetPaginatedRecords(t *testing.T) {
+	expectedJson := `[
+		{
+		  "id": 2,
+		  "title": "Testing Post ID 2",
+		  "author": "Bar"
+		}
+	  ]`
+
+	err := testGetRequest("/posts?_page=2&_limit=1", expectedJson, http.StatusOK, true, true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	resp, err := http.Get("http://" + TestServerAddr + "/posts?_page=2&_limit=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if total := resp.Header.Get("X-Total-Count"); total != "2" {
+		t.Errorf("Expected X-Total-Count 2, got %q", total)
+	}
+
+	if link := resp.Header.Get("Link"); !strings.Contains(link, `rel="prev"`) || strings.Contains(link, `rel="next"`) {
+		t.Errorf("Unexpected Link header %s", link)
+	}
+}
+
+func TestSearchRecordsRequest(t *testing.T) {
+	expectedJson := `[
+		{
+		  "id": 2,
+		  "body": "Testing Comment ID 2",
+		  "postId": 2
+		}
+	  ]`
+
+	err := testGetRequest("/comments?q=comment&postId_gte=2", expectedJson, http.StatusOK, true, true)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetProjectedRecords(t *testing.T) {
+	paths := map[string]string{
+		"/posts?_fields=id,title&_sort=-id": `[
+			{ "id": 2, "title": "Testing Post ID 2" },
+			{ "id": 1, "title": "Testing" }
+		]`,
+		"/posts/1?_exclude=title,author": `{ "id": 1 }`,
+	}
+
+	for path, expectedJson := range paths {
+		err := testGetRequest(path, expectedJson, http.StatusOK, true, true)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestGetEmbeddedRecords(t *testing.T) {
+	paths := map[string]string{
+		"/posts/1?_embed=comments": `{
+			"id": 1,
+			"title": "Testing",
+			"author": "Foo",
+			"comments": [ { "id": 1, "body": "Testing", "postId": 1 } ]
+		}`,
+		"/comments?_expand=post&_fields=id,post.title": `[
+			{ "id": 1, "post": { "title": "Testing" } },
+			{ "id": 2, "post": { "title": "Testing Post ID 2" } }
+		]`,
+	}
+
+	for path, expectedJson := range paths {
+		err := testGetRequest(path, expectedJson, http.StatusOK, true, true)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	err := testGetRequest("/posts?_embed=missing", "", http.StatusBadRequest, true, false)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNestedRoutes(t *testing.T) {
+	databaseBeforeModification := serverData.Copy()
+	defer func() {
+		serverData = databaseBeforeModification
+	}()
+
+	expectedJson := `[ { "id": 2, "body": "Testing Comment ID 2", "postId": 2 } ]`
+
+	err := testGetRequest("/posts/2/comments", expectedJson, http.StatusOK, true, true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = testGetRequest("/posts/9000/comments", "", http.StatusNotFound, true, false)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = makeRequest("POST", "/posts/1/comments", strings.NewReader(`{"body": "Nested"}`), []int{http.StatusCreated})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expectedJson = fmt.Sprintf(`[ { "id": %d, "body": "Nested", "postId": 1 } ]`, maxId("comments"))
+
+	err = testGetRequest("/posts/1/comments?body=Nested", expectedJson, http.StatusOK, true, true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = makeRequest("POST", "/posts/9000/comments", strings.NewReader(`{"body": "Nested"}`), []int{http.StatusNotFound})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReferentialIntegrity(t *testing.T) {
+	databaseBeforeModification := serverData.Copy()
+	relationsBeforeModification := relations
+	defer func() {
+		serverData = databaseBeforeModification
+		relations = relationsBeforeModification
+	}()
+
+	relations = []Relation{
+		Relation{Child: "comments", Parent: "posts", ForeignKey: "postId", OnDelete: OnDeleteRestrict},
+	}
+
+	err := makeRequest("POST", "/comments", strings.NewReader(`{"postId": 9000}`), []int{http.StatusUnprocessableEntity})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = makeRequest("PATCH", "/comments/1", strings.NewReader(`{"postId": 9000}`), []int{http.StatusUnprocessableEntity})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = makeRequest("DELETE", "/posts/1", strings.NewReader(""), []int{http.StatusConflict})
+	if err != nil {
+		t.Error(err)
+	}
+
+	relations[0].OnDelete = OnDeleteCascade
+
+	err = makeRequest("DELETE", "/posts/1", strings.NewReader(""), []int{http.StatusOK})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = testGetRequest("/comments/1", "", http.StatusNotFound, false, false)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSingularResource(t *testing.T) {
+	databaseBeforeModification := serverData.Copy()
+	defer func() {
+		serverData = databaseBeforeModification
+	}()
+
+	err := testGetRequest("/profile", `{"name": "Foo"}`, http.StatusOK, true, true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = makeRequest("PATCH", "/profile", strings.NewReader(`{"email": "foo@example.com"}`), []int{http.StatusOK})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = testGetRequest("/profile", `{"name": "Foo", "email": "foo@example.com"}`, http.StatusOK, true, true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = makeRequest("PUT", "/profile", strings.NewReader(`{"name": "Bar"}`), []int{http.StatusOK})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = testGetRequest("/profile", `{"name": "Bar"}`, http.StatusOK, true, true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = makeRequest("POST", "/profile", strings.NewReader(`{"name": "Bar"}`), []int{http.StatusMethodNotAllowed, http.StatusNotFound})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestScalarTopLevelValuesAreSkipped(t *testing.T) {
+	databaseBeforeModification := serverData
+	relationsBeforeModification := relations
+	defer func() {
+		serverData = databaseBeforeModification
+		relations = relationsBeforeModification
+	}()
+
+	serverData = BackingData{
+		"posts":   []interface{}{},
+		"version": int64(1),
+		"_admin":  []interface{}{map[string]interface{}{"id": int64(1), "postId": int64(1)}},
+		"db":      []interface{}{},
+	}
+
+	// Reserved names would clash with the admin and static routes
+	router := httprouter.New()
+	addStaticRoutes(router)
+	addAdminRoutes(router)
+	addDynamicRoutes(router)
+
+	if handle, _, _ := router.Lookup("GET", "/version"); handle != nil {
+		t.Error("No route should exist for a scalar value")
+	}
+
+	if handle, _, _ := router.Lookup("GET", "/posts"); handle == nil {
+		t.Error("Expected a route for posts")
+	}
+}
+
+func TestStringIds(t *testing.T) {
+	databaseBeforeModification := serverData.Copy()
+	defer func() {
+		serverData = databaseBeforeModification
+	}()
+
+	err := testGetRequest("/tags/abc-123", `{"id": "abc-123", "name": "Foo"}`, http.StatusOK, true, true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// The generated ID is only known from the response
+	resp, err := http.Post("http://" + TestServerAddr + "/tags", "application/json", strings.NewReader(`{"id": "ignored", "name": "Bar"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	created := make(map[string]interface{})
+	if err := decodeJson(resp.Body, &created); err != nil || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected 201 with the created record, got %d (%v)", resp.StatusCode, err)
+	}
+
+	id, ok := created["id"].(string)
+	if !ok || len(id) != 36 || created["name"] != "Bar" {
+		t.Fatalf("Expected a UUID to be generated, got %#v", created)
+	}
+
+	err = testGetRequest("/tags/"+id, fmt.Sprintf(`{"id": %q, "name": "Bar"}`, id), http.StatusOK, true, true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = makeRequest("DELETE", "/tags/abc-123", strings.NewReader(""), []int{http.StatusOK})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = testGetRequest("/tags/abc-123", "", http.StatusNotFound, true, false)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPostPreservesFloats(t *testing.T) {
+	databaseBeforeModification := serverData.Copy()
+	defer func() {
+		serverData = databaseBeforeModification
+	}()
+
+	err := makeRequest("POST", "/posts", strings.NewReader(`{"title": "Priced", "price": 19.99}`), []int{http.StatusCreated})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedJson := fmt.Sprintf(`[{"id": %d, "title": "Priced", "price": 19.99}]`, maxId("posts"))
+
+	err = testGetRequest("/posts?price_lt=20", expectedJson, http.StatusOK, true, true)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	databaseBeforeModification := serverData.Copy()
+	defer func() {
+		serverData = databaseBeforeModification
+	}()
+
+	var wait sync.WaitGroup
+
+	for worker := 0; worker < 8; worker++ {
+		wait.Add(1)
+
+		go func(worker int) {
+			defer wait.Done()
+
+			postPath := fmt.Sprintf("/posts/%d", 1000+worker)
+
+			requests := []struct {
+				method string
+				path   string
+				body   string
+			}{
+				{"PUT", postPath, `{"title": "Concurrent"}`},
+				{"PATCH", postPath, `{"views": 1}`},
+				{"PUT", fmt.Sprintf("/comments/%d", 1000+worker), fmt.Sprintf(`{"body": "Concurrent", "postId": %d}`, 1000+worker)},
+				{"POST", "/comments", `{"body": "Concurrent", "postId": 1}`},
+				{"GET", postPath + "?_embed=comments", ""},
+				{"GET", "/posts?title=Concurrent&_sort=-id", ""},
+				{"GET", postPath + "/comments", ""},
+				{"GET", "/db", ""},
+				{"PATCH", "/profile", `{"name": "Concurrent"}`},
+				{"GET", "/profile", ""},
+				{"DELETE", postPath, ""},
+			}
+
+			for i := 0; i < 50; i++ {
+				for _, request := range requests {
+					var body io.Reader
+					if request.body != "" {
+						body = strings.NewReader(request.body)
+					}
+
+					err := makeRequest(request.method, request.path, body, []int{http.StatusOK, http.StatusCreated, http.StatusNotFound})
+					if err != nil {
+						t.Errorf("%s %s: %s", request.method, request.path, err)
+					}
+				}
+			}
+		}(worker)
+	}
+
+	wait.Wait()
+}
+
+func TestSlowRequestBody(t *testing.T) {
+	body, writer := io.Pipe()
+
+	req, err := http.NewRequest("PATCH", "http://" + TestServerAddr + "/posts/1", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	done := make(chan error, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+
+		done <- err
+	}()
+
+	// Send part of the body and stall, which mustn't keep the collection locked
+	writer.Write([]byte(`{"title": `))
+	time.Sleep(100 * time.Millisecond)
+
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Get("http://" + TestServerAddr + "/posts")
+	if err != nil {
+		t.Error(err)
+	} else {
+		resp.Body.Close()
+	}
+
+	writer.Write([]byte(`"Testing"}`))
+	writer.Close()
+
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAdminFlush(t *testing.T) {
+	databaseBeforeModification := serverData.Copy()
+	defer func() {
+		serverData = databaseBeforeModification
+	}()
+
+	err := makeRequest("PATCH", "/profile", strings.NewReader(`{"name": "Flushed"}`), []int{http.StatusOK})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = makeRequest("POST", "/_admin/flush", nil, []int{http.StatusNoContent})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := ioutil.ReadFile(JsonFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(contents), `"name":"Flushed"`) {
+		t.Errorf("Expected the change to be written to %s, got %s", JsonFilePath, contents)
+	}
+}
+
+func TestSchemaValidation(t *testing.T) {
+	databaseBeforeModification := serverData.Copy()
+	defer func() {
+		serverData = databaseBeforeModification
+		delete(config.Collections, "posts")
+	}()
+
+	schema := &Schema{Type: "object", Required: []string{"title"}, Properties: map[string]*Schema{"title": &Schema{Type: "string"}}}
+	config.Collections["posts"] = CollectionConfig{Schema: schema}
+
+	err := makeRequest("POST", "/posts", strings.NewReader(`{"author": "Foo"}`), []int{http.StatusUnprocessableEntity})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = makeRequest("PUT", "/posts/1", strings.NewReader(`{"title": 1}`), []int{http.StatusUnprocessableEntity})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = makeRequest("PATCH", "/posts/1", strings.NewReader(`{"title": null}`), []int{http.StatusUnprocessableEntity})
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = makeRequest("POST", "/posts", strings.NewReader(`{"title": "Valid"}`), []int{http.StatusCreated})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAdminCollections(t *testing.T) {
+	databaseBeforeModification := serverData.Copy()
+	defer func() {
+		serverData = databaseBeforeModification
+	}()
+
+	err := makeRequest("POST", "/_admin/collections", strings.NewReader(`{"name": "widgets", "records": [{"id": 1}]}`), []int{http.StatusCreated})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The routes of the new collection are served as soon as it has been created
+	if err := testGetRequest("/widgets/1", `{"id": 1}`, http.StatusOK, false, true); err != nil {
+		t.Error(err)
+	}
+
+	err = makeRequest("POST", "/widgets", strings.NewReader(`{"name": "Foo"}`), []int{http.StatusCreated})
+	if err != nil {
+		t.Error(err)
+	}
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"name": "widgets"}`, http.StatusConflict},
+		{`{"name": "posts"}`, http.StatusConflict},
+		{`{"name": "_admin"}`, http.StatusBadRequest},
+		{`{"name": "a/b"}`, http.StatusBadRequest},
+		{`{"name": "gadgets", "records": [1]}`, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		if err := makeRequest("POST", "/_admin/collections", strings.NewReader(test.body), []int{test.status}); err != nil {
+			t.Errorf("%s: %s", test.body, err)
+		}
+	}
+
+	resp, err := http.Get("http://" + TestServerAddr + "/_admin/collections")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collections := []map[string]interface{}{}
+	decodeJson(resp.Body, &collections)
+	resp.Body.Close()
+
+	listed := false
+	for _, collection := range collections {
+		listed = listed || (collection["name"] == "widgets" && fmt.Sprint(collection["count"]) == "2")
+	}
+
+	if !listed {
+		t.Errorf("Expected widgets to be listed with 2 records, got %v", collections)
+	}
+
+	if err := makeRequest("DELETE", "/_admin/collections/profile", nil, []int{http.StatusConflict}); err != nil {
+		t.Error(err)
+	}
+
+	if err := makeRequest("DELETE", "/_admin/collections/widgets", nil, []int{http.StatusNoContent}); err != nil {
+		t.Error(err)
+	}
+
+	if err := makeRequest("GET", "/widgets", nil, []int{http.StatusNotFound}); err != nil {
+		t.Error(err)
+	}
+
+	if err := makeRequest("DELETE", "/_admin/collections/widgets", nil, []int{http.StatusNotFound}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAdminResetAndSnapshots(t *testing.T) {
+	databaseBeforeModification := serverData.Copy()
+	defer func() {
+		serverData = databaseBeforeModification
+	}()
+
+	dataMutex.Lock()
+	serverData.RestoreData(initialData)
+	dataMutex.Unlock()
+
+	if err := makeRequest("POST", "/_admin/snapshots/clean", nil, []int{http.StatusCreated}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := makeRequest("DELETE", "/posts/1", nil, []int{http.StatusOK}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := makeRequest("POST", "/_admin/collections", strings.NewReader(`{"name": "widgets"}`), []int{http.StatusCreated}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := makeRequest("POST", "/_admin/snapshots/modified", nil, []int{http.StatusCreated}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := makeRequest("POST", "/_admin/reset", nil, []int{http.StatusNoContent}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := makeRequest("GET", "/posts/1", nil, []int{http.StatusOK}); err != nil {
+		t.Errorf("Expected the deleted post to be restored: %s", err)
+	}
+
+	if err := makeRequest("GET", "/widgets", nil, []int{http.StatusNotFound}); err != nil {
+		t.Errorf("Expected the created collection to be gone: %s", err)
+	}
+
+	if err := makeRequest("POST", "/_admin/snapshots/modified/restore", nil, []int{http.StatusNoContent}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := makeRequest("GET", "/posts/1", nil, []int{http.StatusNotFound}); err != nil {
+		t.Errorf("Expected the snapshot to be restored: %s", err)
+	}
+
+	if err := makeRequest("GET", "/widgets", nil, []int{http.StatusOK}); err != nil {
+		t.Errorf("Expected the routes of the snapshot to be served: %s", err)
+	}
+
+	// A snapshot can be restored more than once
+	for i := 0; i < 2; i++ {
+		if err := makeRequest("DELETE", "/comments/1", nil, []int{http.StatusOK}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := makeRequest("POST", "/_admin/snapshots/clean/restore", nil, []int{http.StatusNoContent}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := makeRequest("GET", "/comments/1", nil, []int{http.StatusOK}); err != nil {
+		t.Errorf("Expected the clean snapshot to be restored: %s", err)
+	}
+
+	resp, err := http.Get("http://" + TestServerAddr + "/_admin/snapshots")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if match, err, expected, actual := jsonResponseMatchesActual(resp, `["clean", "modified"]`, false); err != nil || !match {
+		t.Errorf("Expected the snapshots to be listed (%v): %v, got %v", err, expected, actual)
+	}
+
+	if err := makeRequest("DELETE", "/_admin/snapshots/modified", nil, []int{http.StatusNoContent}); err != nil {
+		t.Error(err)
+	}
+
+	if err := makeRequest("POST", "/_admin/snapshots/modified/restore", nil, []int{http.StatusNotFound}); err != nil {
+		t.Error(err)
+	}
+
+	if err := makeRequest("DELETE", "/_admin/snapshots/clean", nil, []int{http.StatusNoContent}); err != nil {
+		t.Error(err)
+	}
+}
+
+// Makes a request at `path` and compares the JSON response to `expectedJson` (an object)
+//
+func testJsonResponse(method string, path string, body string, expectedStatus int, expectedJson string) error {
+	return testJsonResponseWithContentType(method, path, "application/json", body, expectedStatus, expectedJson)
+}
+
+// Same as testJsonResponse, but with the given Content-Type. If `expectedJson` is empty, only the status is compared.
+//
+func testJsonResponseWithContentType(method string, path string, contentType string, body string, expectedStatus int, expectedJson string) error {
+	req, err := http.NewRequest(method, "http://" + TestServerAddr + path, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != expectedStatus {
+		return fmt.Errorf("Unexpected status code for %s %s. Expected %d, got %d\n", method, path, expectedStatus, resp.StatusCode)
+	}
+
+	if expectedJson == "" {
+		return nil
+	}
+
+	match, err, expected, actual := jsonResponseMatchesActual(resp, expectedJson, true)
+	if err != nil {
+		return err
+	}
+
+	if !match {
+		return fmt.Errorf("Data mismatch for %s %s.\n Expected:\n%#v\n\ngot\n%#v", method, path, expected, actual)
+	}
+
+	return nil
+}
+
+func TestPutReplacesRecord(t *testing.T) {
+	databaseBeforeModification := serverData.Copy()
+	defer func() {
+		serverData = databaseBeforeModification
+	}()
+
+	err := testJsonResponse("PUT", "/posts/5000", `{"title": "Foo", "meta": {"lang": "en"}}`, http.StatusCreated, `{"id": 5000, "title": "Foo", "meta": {"lang": "en"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// Fields missing from the body are removed rather than set to null, and the ID can't be changed
+	err = testJsonResponse("PUT", "/posts/5000", `{"id": 1, "title": "Bar"}`, http.StatusOK, `{"id": 5000, "title": "Bar"}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = testGetRequest("/posts/5000", `{"id": 5000, "title": "Bar"}`, http.StatusOK, true, true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = testJsonResponse("PUT", "/profile", `{"email": "foo@example.com"}`, http.StatusOK, `{"email": "foo@example.com"}`)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPatchMergesRecord(t *testing.T) {
+	databaseBeforeModification := serverData.Copy()
+	defer func() {
+		serverData = databaseBeforeModification
+	}()
+
+	err := makeRequest("PUT", "/posts/5000", strings.NewReader(`{"title": "Foo", "meta": {"lang": "en", "draft": true}}`), []int{http.StatusCreated})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = testJsonResponse("PATCH", "/posts/5000", `{"author": "Baz", "meta": {"draft": null, "tags": ["a"]}}`, http.StatusOK, `{"id": 5000, "title": "Foo", "author": "Baz", "meta": {"lang": "en", "tags": ["a"]}}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = testJsonResponse("PATCH", "/posts/5000", `{"id": null, "title": null, "meta": {"tags": ["b"]}}`, http.StatusOK, `{"id": 5000, "author": "Baz", "meta": {"lang": "en", "tags": ["b"]}}`)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = testGetRequest("/posts/5000", `{"id": 5000, "author": "Baz", "meta": {"lang": "en", "tags": ["b"]}}`, http.StatusOK, true, true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = testJsonResponse("PATCH", "/profile", `{"name": null, "address": {"city": "Foo"}}`, http.StatusOK, `{"address": {"city": "Foo"}}`)
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJsonPatchRecord(t *testing.T) {
+	databaseBeforeModification := serverData.Copy()
+	defer func() {
+		serverData = databaseBeforeModification
+	}()
+
+	err := makeRequest("PUT", "/posts/5000", strings.NewReader(`{"title": "Foo", "tags": ["a", "b"], "meta": {"lang": "en"}}`), []int{http.StatusCreated})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	patch := `[
+		{"op": "test", "path": "/title", "value": "Foo"},
+		{"op": "replace", "path": "/title", "value": "Bar"},
+		{"op": "add", "path": "/tags/1", "value": "c"},
+		{"op": "remove", "path": "/tags/0"},
+		{"op": "copy", "from": "/meta/lang", "path": "/lang"},
+		{"op": "move", "from": "/meta", "path": "/info"}
+	]`
+
+	expectedJson := `{"id": 5000, "title": "Bar", "tags": ["c", "b"], "lang": "en", "info": {"lang": "en"}}`
+
+	err = testJsonResponseWithContentType("PATCH", "/posts/5000", jsonPatchContentType, patch, http.StatusOK, expectedJson)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// A failed test or operation leaves the record unchanged
+	failing := map[string]int{
+		`[{"op": "remove", "path": "/title"}, {"op": "test", "path": "/lang", "value": "fr"}]`: http.StatusConflict,
+		`[{"op": "remove", "path": "/title"}, {"op": "remove", "path": "/missing"}]`:           http.StatusUnprocessableEntity,
+		`[{"op": "replace", "path": "", "value": [1]}]`:                                       http.StatusUnprocessableEntity,
+		`[{"op": "invalid", "path": "/title"}]`:                                               http.StatusBadRequest,
+		`{"op": "remove", "path": "/title"}`:                                                  http.StatusBadRequest,
+	}
+
+	for patch, status := range failing {
+		err = testJsonResponseWithContentType("PATCH", "/posts/5000", jsonPatchContentType, patch, status, "")
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	err = testGetRequest("/posts/5000", expectedJson, http.StatusOK, true, true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	err = testJsonResponseWithContentType("PATCH", "/profile", jsonPatchContentType+"; charset=utf-8", `[{"op": "add", "path": "/email", "value": "foo@example.com"}]`, http.StatusOK, `{"name": "Foo", "email": "foo@example.com"}`)
+	if err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Strategies used to generate the ID of records created without one
+//
+const (
+	IdStrategyAutoIncrement = "autoincrement"
+	IdStrategyUUID          = "uuid"
+	IdStrategyULID          = "ulid"
+)
+
+const defaultPrimaryKey = "id"
+
+// validIdStrategy returns whether `strategy` is one of the IdStrategy* constants
+//
+func validIdStrategy(strategy string) bool {
+	switch strategy {
+	case IdStrategyAutoIncrement, IdStrategyUUID, IdStrategyULID:
+		return true
+	}
+
+	return false
+}
+
+// idKey returns the canonical string form of an ID so that IDs stored as numbers or strings can be compared with
+// each other and with the `:id` URL parameter. The second return value is false if `id` can't be used as an ID.
+//
+func idKey(id interface{}) (string, bool) {
+	switch id := id.(type) {
+	case string:
+		return id, true
+	case int64:
+		return strconv.FormatInt(id, 10), true
+	case int:
+		return strconv.Itoa(id), true
+	case float64:
+		return strconv.FormatFloat(id, 'f', -1, 64), true
+	case json.Number:
+		return id.String(), true
+	}
+
+	return "", false
+}
+
+// sameId returns whether two IDs are equal according to idKey
+//
+func sameId(a, b interface{}) bool {
+	aKey, aOk := idKey(a)
+	bKey, bOk := idKey(b)
+
+	return aOk && bOk && aKey == bKey
+}
+
+// primaryKey returns the name of the field holding the ID of records in `itemType`
+//
+func primaryKey(itemType string) string {
+	if key := config.Collections[itemType].PrimaryKey; key != "" {
+		return key
+	}
+
+	return defaultPrimaryKey
+}
+
+// idStrategy returns how IDs are generated for `itemType`. If it isn't configured, collections whose records have
+// string IDs use ULIDs or UUIDs (depending on what the existing IDs look like), and all others auto-increment.
+//
+func idStrategy(itemType string) string {
+	if strategy := config.Collections[itemType].IdStrategy; strategy != "" {
+		return strategy
+	}
+
+	rows, _ := serverData.ItemType(itemType)
+	for _, row := range rows {
+		record, ok := row.(map[string]interface{})
+		if !ok || record[primaryKey(itemType)] == nil {
+			continue
+		}
+
+		if id, ok := record[primaryKey(itemType)].(string); ok {
+			if looksLikeULID(id) {
+				return IdStrategyULID
+			}
+
+			return IdStrategyUUID
+		}
+
+		break
+	}
+
+	return IdStrategyAutoIncrement
+}
+
+// parseIdParam converts the `:id` URL parameter to the type of ID used by `itemType`. Auto-incrementing
+// collections use integer IDs where possible.
+//
+func parseIdParam(itemType string, param string) interface{} {
+	if idStrategy(itemType) == IdStrategyAutoIncrement {
+		if id, err := strconv.ParseInt(param, 10, 64); err == nil {
+			return id
+		}
+	}
+
+	return param
+}
+
+// newUUID returns a random (version 4) UUID
+//
+func newUUID() string {
+	var uuid [16]byte
+	rand.Read(uuid[:])
+
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}
+
+const crockfordAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a ULID (https://github.com/ulid/spec): a 48 bit millisecond timestamp followed by 80 random bits,
+// encoded as 26 characters of Crockford's base32 so that IDs sort by creation time
+//
+func newULID(now time.Time) string {
+	var data [16]byte
+
+	binary.BigEndian.PutUint64(data[0:8], uint64(now.UnixNano()/int64(time.Millisecond))<<16)
+	rand.Read(data[6:])
+
+	high := binary.BigEndian.Uint64(data[0:8])
+	low := binary.BigEndian.Uint64(data[8:16])
+
+	// 26 characters of 5 bits is 130 bits, so the first character only holds the top 3 bits
+	encoded := make([]byte, 26)
+	for i := 25; i >= 0; i-- {
+		encoded[i] = crockfordAlphabet[low&0x1f]
+		low = (low >> 5) | (high << 59)
+		high >>= 5
+	}
+
+	return string(encoded)
+}
+
+func looksLikeULID(id string) bool {
+	if len(id) != 26 {
+		return false
+	}
+
+	for _, c := range strings.ToUpper(id) {
+		if !strings.ContainsRune(crockfordAlphabet, c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// nextId returns an unused ID for a new record of `itemType` according to its ID strategy. The collection must be
+// write locked by the caller.
+//
+func nextId(itemType string) interface{} {
+	switch idStrategy(itemType) {
+	case IdStrategyUUID:
+		return newUUID()
+	case IdStrategyULID:
+		return newULID(time.Now())
+	}
+
+	collection, err := serverData.collection(itemType)
+	if err != nil {
+		return int64(1)
+	}
+
+	// Start from the highest ID in use (or 1 if there are no records) and skip any which are taken, e.g. by a
+	// record with the string ID "5"
+	id := collection.maxId
+	if id < 1 {
+		id = 1
+	}
+
+	for _, err = serverData.RecordWithId(itemType, id); err != ErrorNotFound; _, err = serverData.RecordWithId(itemType, id) {
+		id++
+	}
+
+	collection.maxId = id
+
+	return id
+}
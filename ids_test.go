@@ -0,0 +1,141 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestIdKey(t *testing.T) {
+	tests := map[interface{}]string{
+		int64(1): "1",
+		1:        "1",
+		"abc":    "abc",
+	}
+
+	for id, expected := range tests {
+		if key, ok := idKey(id); !ok || key != expected {
+			t.Errorf("idKey(%#v): expected %q, got %q", id, expected, key)
+		}
+	}
+
+	for _, id := range []interface{}{nil, true, map[string]interface{}{}} {
+		if _, ok := idKey(id); ok {
+			t.Errorf("idKey(%#v) should not be a valid ID", id)
+		}
+	}
+
+	if !sameId(int64(1), "1") || sameId(int64(1), int64(2)) || sameId(nil, nil) {
+		t.Error("sameId returned an unexpected result")
+	}
+}
+
+func TestNewUUID(t *testing.T) {
+	pattern := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+	if uuid := newUUID(); !pattern.MatchString(uuid) {
+		t.Errorf("Invalid UUID %s", uuid)
+	}
+
+	if newUUID() == newUUID() {
+		t.Error("UUIDs should be unique")
+	}
+}
+
+func TestNewULID(t *testing.T) {
+	now := time.Unix(1469918176, 385000000)
+	ulid := newULID(now)
+
+	if !looksLikeULID(ulid) {
+		t.Errorf("Invalid ULID %s", ulid)
+	}
+
+	// The first 10 characters encode the timestamp (this one is from the ULID spec)
+	if prefix := ulid[:10]; prefix != "01ARYZ6S41" {
+		t.Errorf("Expected timestamp prefix 01ARYZ6S41, got %s", prefix)
+	}
+
+	if later := newULID(now.Add(time.Millisecond)); later <= ulid {
+		t.Errorf("ULIDs should sort by time, %s <= %s", later, ulid)
+	}
+}
+
+func TestIdStrategyAndPrimaryKey(t *testing.T) {
+	defer withDefaultConfig()()
+
+	databaseBeforeModification := serverData
+	defer func() {
+		serverData = databaseBeforeModification
+	}()
+
+	serverData = BackingData{
+		"posts":  []interface{}{map[string]interface{}{"id": int64(1)}},
+		"users":  []interface{}{map[string]interface{}{"id": nil}, map[string]interface{}{"id": "9b2c0b64-2f3e-4c43-a0b1-0b1b8e3f4a52"}},
+		"events": []interface{}{map[string]interface{}{"id": "01ARZ3NDEKTSV4RRFFQ69G5FAV"}},
+		"pages":  []interface{}{map[string]interface{}{"slug": "about", "title": "About"}},
+	}
+
+	tests := map[string]string{
+		"posts":  IdStrategyAutoIncrement,
+		"users":  IdStrategyUUID,
+		"events": IdStrategyULID,
+		"empty":  IdStrategyAutoIncrement,
+	}
+
+	for itemType, expected := range tests {
+		if strategy := idStrategy(itemType); strategy != expected {
+			t.Errorf("%s: expected strategy %s, got %s", itemType, expected, strategy)
+		}
+	}
+
+	if id := parseIdParam("posts", "1"); id != int64(1) {
+		t.Errorf("Expected an integer ID, got %#v", id)
+	}
+
+	if id := parseIdParam("users", "1"); id != "1" {
+		t.Errorf("Expected a string ID, got %#v", id)
+	}
+
+	config.Collections["pages"] = CollectionConfig{PrimaryKey: "slug", IdStrategy: IdStrategyUUID}
+
+	page, err := serverData.RecordWithId("pages", "about")
+	if err != nil || page["title"] != "About" {
+		t.Errorf("Expected to find the page by slug, got %#v (error %v)", page, err)
+	}
+
+	if id, ok := nextId("pages").(string); !ok || len(id) != 36 {
+		t.Errorf("Expected a UUID, got %#v", id)
+	}
+}
+
+func TestParseAssignments(t *testing.T) {
+	assignments, err := parseAssignments("users=_id, pages=slug,")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(assignments) != 2 || assignments["users"] != "_id" || assignments["pages"] != "slug" {
+		t.Errorf("Unexpected assignments %#v", assignments)
+	}
+
+	for _, invalid := range []string{"users", "=slug", "users="} {
+		if _, err := parseAssignments(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestNextIdOfEmptyCollection(t *testing.T) {
+	defer disableWal()()
+
+	previousData := serverData
+	defer func() {
+		serverData = previousData
+	}()
+
+	serverData = BackingData{"tags": newCollection("tags", nil)}
+
+	if id := nextId("tags"); id != int64(1) {
+		t.Errorf("Expected the first ID to be 1, got %#v", id)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strconv"
+)
+
+// fieldIndex orders the records of a collection by the value of a single (possibly dotted) field, so that equality
+// and range filters on that field only have to look at the records which can match. Records without the field are
+// left out of the index.
+//
+type fieldIndex struct {
+	path    string
+	entries []indexEntry
+}
+
+// indexEntry is the value of the indexed field of the record at `position` within the collection
+//
+type indexEntry struct {
+	value    interface{}
+	position int
+}
+
+// compareEntries orders entries by value (see compareSortValues) and then by position
+//
+func compareEntries(a, b indexEntry) int {
+	if comparison := compareSortValues(a.value, b.value); comparison != 0 {
+		return comparison
+	}
+
+	return a.position - b.position
+}
+
+func newFieldIndex(path string, rows []interface{}) *fieldIndex {
+	index := &fieldIndex{path: path, entries: make([]indexEntry, 0, len(rows))}
+
+	for position, row := range rows {
+		record, _ := row.(map[string]interface{})
+
+		if value, ok := lookupPath(record, path); ok {
+			index.entries = append(index.entries, indexEntry{value, position})
+		}
+	}
+
+	sort.Slice(index.entries, func(i, j int) bool {
+		return compareEntries(index.entries[i], index.entries[j]) < 0
+	})
+
+	return index
+}
+
+// search returns the position of the first entry which isn't less than `entry`
+//
+func (f *fieldIndex) search(entry indexEntry) int {
+	return sort.Search(len(f.entries), func(i int) bool {
+		return compareEntries(f.entries[i], entry) >= 0
+	})
+}
+
+// insert adds `record`, which is stored at `position`, to the index
+//
+func (f *fieldIndex) insert(record map[string]interface{}, position int) {
+	value, ok := lookupPath(record, f.path)
+	if !ok {
+		return
+	}
+
+	entry := indexEntry{value, position}
+	i := f.search(entry)
+
+	f.entries = append(f.entries, indexEntry{})
+	copy(f.entries[i+1:], f.entries[i:])
+	f.entries[i] = entry
+}
+
+// remove drops the entry of `record`, which is stored at `position`
+//
+func (f *fieldIndex) remove(record map[string]interface{}, position int) {
+	value, ok := lookupPath(record, f.path)
+	if !ok {
+		return
+	}
+
+	i := f.search(indexEntry{value, position})
+
+	// If the record was modified in place since it was indexed, its entry isn't where its value says it should be
+	if i >= len(f.entries) || f.entries[i].position != position {
+		for i = range f.entries {
+			if f.entries[i].position == position {
+				break
+			}
+		}
+	}
+
+	if i < len(f.entries) && f.entries[i].position == position {
+		f.entries = append(f.entries[:i], f.entries[i+1:]...)
+	}
+}
+
+// shift moves the entries after `position` down by one after the record at `position` was removed. Entries keep
+// their relative order, so the index stays sorted.
+//
+func (f *fieldIndex) shift(position int) {
+	for i := range f.entries {
+		if f.entries[i].position > position {
+			f.entries[i].position--
+		}
+	}
+}
+
+// candidates returns the positions of the records which may match `filter`, in no particular order. Every record
+// which matches is included, but the filter must still be applied to the result. The second return value is false
+// if the filter's operator can't be answered by the index.
+//
+func (f *fieldIndex) candidates(filter recordFilter) ([]int, bool) {
+	values := filter.Values
+
+	switch filter.Operator {
+	case opEqual, opIn:
+	case opGreater, opGreaterOrEqual, opLess, opLessOrEqual:
+		// Range operators must hold for every value, so the records matching the first are a superset
+		values = values[:1]
+	default:
+		return nil, false
+	}
+
+	positions := []int{}
+
+	for _, raw := range values {
+		for _, probe := range queryProbes(raw) {
+			start, end := f.probeRange(filter.Operator, probe)
+
+			for _, entry := range f.entries[start:end] {
+				positions = append(positions, entry.position)
+			}
+		}
+	}
+
+	return positions, true
+}
+
+// probeRange returns the range of entries which compare to `probe` according to `operator`. Query values are
+// coerced to the type of the stored value, so only stored values of the same type as the probe are included.
+//
+func (f *fieldIndex) probeRange(operator string, probe interface{}) (int, int) {
+	// null can only be tested for equality
+	if probe == nil && operator != opEqual && operator != opIn {
+		return 0, 0
+	}
+
+	rank := sortTypeRank(probe)
+
+	rankStart := sort.Search(len(f.entries), func(i int) bool {
+		return sortTypeRank(f.entries[i].value) >= rank
+	})
+	rankEnd := sort.Search(len(f.entries), func(i int) bool {
+		return sortTypeRank(f.entries[i].value) > rank
+	})
+	lower := sort.Search(len(f.entries), func(i int) bool {
+		return compareSortValues(f.entries[i].value, probe) >= 0
+	})
+	upper := sort.Search(len(f.entries), func(i int) bool {
+		return compareSortValues(f.entries[i].value, probe) > 0
+	})
+
+	switch operator {
+	case opGreater:
+		return upper, rankEnd
+	case opGreaterOrEqual:
+		return lower, rankEnd
+	case opLess:
+		return rankStart, lower
+	case opLessOrEqual:
+		return rankStart, upper
+	}
+
+	return lower, upper
+}
+
+// queryProbes returns every value a raw query string value may be coerced to (see coerceQueryValue)
+//
+func queryProbes(raw string) []interface{} {
+	probes := []interface{}{raw}
+
+	if numberPattern.MatchString(raw) {
+		probes = append(probes, json.Number(raw))
+	}
+
+	if boolean, err := strconv.ParseBool(raw); err == nil {
+		probes = append(probes, boolean)
+	}
+
+	if raw == "null" {
+		probes = append(probes, nil)
+	}
+
+	return probes
+}
+
+// addIndex indexes the records of the collection by `path`. Indexing a path twice has no effect.
+//
+func (c *Collection) addIndex(path string) {
+	if _, ok := c.indexes[path]; ok {
+		return
+	}
+
+	c.indexes[path] = newFieldIndex(path, c.Rows)
+}
+
+// filterCandidates narrows the records of the collection down to the ones which may match the filters in `query`
+// using the most selective index. The filters still have to be applied to the result. If no filter can use an
+// index, all records are returned.
+//
+func (c *Collection) filterCandidates(query url.Values) []interface{} {
+	filters, err := parseFilters(query)
+	if err != nil || len(c.indexes) == 0 {
+		return c.Rows
+	}
+
+	var best []int
+	found := false
+
+	for _, filter := range filters {
+		index, ok := c.indexes[filter.Path]
+		if !ok {
+			continue
+		}
+
+		positions, ok := index.candidates(filter)
+		if ok && (!found || len(positions) < len(best)) {
+			best = positions
+			found = true
+		}
+	}
+
+	if !found {
+		return c.Rows
+	}
+
+	// Keep the records in collection order, as if the whole collection had been scanned
+	sort.Ints(best)
+
+	rows := make([]interface{}, 0, len(best))
+	for i, position := range best {
+		if i > 0 && position == best[i-1] {
+			continue
+		}
+
+		rows = append(rows, c.Rows[position])
+	}
+
+	return rows
+}
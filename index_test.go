@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func indexTestRows() []interface{} {
+	rows := []interface{}{}
+	authors := []interface{}{"Foo", "Bar", "10", int64(10), true, nil, 2.5, json.Number("12345678901234567890")}
+
+	for i := 0; i < 40; i++ {
+		record := map[string]interface{}{
+			"id":     int64(i + 1),
+			"author": authors[i%len(authors)],
+			"views":  int64((i * 7) % 13),
+			"meta":   map[string]interface{}{"lang": []interface{}{"en", "de"}[i%2]},
+		}
+
+		// Some records don't have the indexed fields at all
+		if i%5 == 0 {
+			delete(record, "views")
+		}
+
+		rows = append(rows, record)
+	}
+
+	return rows
+}
+
+// assertIndexedFilter checks that filtering the candidates returned by the indexes gives the same result as
+// filtering the whole collection
+//
+func assertIndexedFilter(t *testing.T, collection *Collection, rawQuery string) {
+	query, _ := url.ParseQuery(rawQuery)
+
+	expected, err := filterRecords(collection.Rows, query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := filterRecords(collection.filterCandidates(query), query)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(rowIds(actual), rowIds(expected)) {
+		t.Errorf("%s: expected %v, got %v", rawQuery, rowIds(expected), rowIds(actual))
+	}
+}
+
+func TestIndexedFilters(t *testing.T) {
+	collection := newCollection("posts", indexTestRows())
+	collection.addIndex("author")
+	collection.addIndex("views")
+	collection.addIndex("meta.lang")
+
+	queries := []string{
+		"author=Foo",
+		"author=10",
+		"author=10.0",
+		"author=true",
+		"author=1",
+		"author=null",
+		"author=2.5",
+		"author=12345678901234567890",
+		"author=Foo&author=Bar",
+		"author_in=Foo,10",
+		"author_gt=Bar",
+		"author_lte=10",
+		"author_ne=Foo",
+		"author_like=^F",
+		"author_exists=false",
+		"views=3",
+		"views_gt=3",
+		"views_gte=3",
+		"views_lt=3",
+		"views_lte=3",
+		"views_gt=3&views_lt=10",
+		"views_gt=3&views_gt=8",
+		"views_gte=a",
+		"views=3&author=Foo",
+		"meta.lang=de&views_lt=5",
+		"views=9000",
+		"title=Foo",
+	}
+
+	for _, query := range queries {
+		assertIndexedFilter(t, collection, query)
+	}
+
+	// Queries which can't use an index return every record
+	query, _ := url.ParseQuery("author_ne=Foo")
+	if candidates := collection.filterCandidates(query); len(candidates) != len(collection.Rows) {
+		t.Errorf("Expected all %d records, got %d", len(collection.Rows), len(candidates))
+	}
+
+	query, _ = url.ParseQuery("views=3")
+	if candidates := collection.filterCandidates(query); len(candidates) >= len(collection.Rows) {
+		t.Errorf("Expected the index to narrow down the records, got %d", len(candidates))
+	}
+}
+
+func TestIndexMaintenance(t *testing.T) {
+	collection := newCollection("posts", indexTestRows())
+	collection.addIndex("author")
+	collection.addIndex("views")
+
+	queries := []string{"author=Foo", "author=Baz", "views_gte=5", "views=3", "author=null"}
+
+	check := func(step string) {
+		for _, query := range queries {
+			assertIndexedFilter(t, collection, query)
+		}
+
+		for path, index := range collection.indexes {
+			if !reflect.DeepEqual(index.entries, newFieldIndex(path, collection.Rows).entries) {
+				t.Errorf("%s: index on %s is out of date", step, path)
+			}
+		}
+	}
+
+	collection.add(map[string]interface{}{"id": int64(100), "author": "Baz", "views": int64(5)})
+	check("add")
+
+	collection.removeAt(0)
+	collection.removeAt(10)
+	check("remove")
+
+	collection.replaceAt(3, map[string]interface{}{"id": int64(4), "author": "Baz"})
+	collection.replaceAt(5, map[string]interface{}{"id": int64(6), "author": "Foo", "views": int64(3)})
+	check("replace")
+
+	for len(collection.Rows) > 0 {
+		collection.removeAt(len(collection.Rows) / 2)
+	}
+
+	check("remove all")
+
+	copied := newCollection("posts", indexTestRows())
+	copied.addIndex("author")
+	copied = copied.copy()
+	collection = copied
+	check("copy")
+}
+
+func TestParseIndexes(t *testing.T) {
+	indexes, err := parseIndexes("posts.author, posts.meta.lang,users.email,")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][]string{
+		"posts": []string{"author", "meta.lang"},
+		"users": []string{"email"},
+	}
+
+	if !reflect.DeepEqual(indexes, expected) {
+		t.Errorf("Expected %v, got %v", expected, indexes)
+	}
+
+	for _, invalid := range []string{"posts", ".author", "posts."} {
+		if _, err := parseIndexes(invalid); err == nil {
+			t.Errorf("Expected %q to be invalid", invalid)
+		}
+	}
+}
+
+func indexBenchmarkCollection(indexed bool) *Collection {
+	rows := collectionTestRows(benchmarkRecordCount)
+	for i, row := range rows {
+		row.(map[string]interface{})["author"] = fmt.Sprintf("author%d", i%1000)
+	}
+
+	collection := newCollection("posts", rows)
+	if indexed {
+		collection.addIndex("author")
+	}
+
+	return collection
+}
+
+func benchmarkFilter(b *testing.B, indexed bool) {
+	collection := indexBenchmarkCollection(indexed)
+	query, _ := url.ParseQuery("author=author42")
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := filterRecords(collection.filterCandidates(query), query); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFilterScan(b *testing.B) {
+	benchmarkFilter(b, false)
+}
+
+func BenchmarkFilterIndexed(b *testing.B) {
+	benchmarkFilter(b, true)
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Policies which decide what happens to the records referring to a record when it is deleted
+//
+const (
+	// OnDeleteNone leaves referencing records untouched and does not validate foreign keys
+	OnDeleteNone = "none"
+	// OnDeleteCascade deletes referencing records
+	OnDeleteCascade = "cascade"
+	// OnDeleteSetNull sets the foreign key of referencing records to null
+	OnDeleteSetNull = "set-null"
+	// OnDeleteRestrict refuses to delete a record which is still referenced
+	OnDeleteRestrict = "restrict"
+)
+
+var (
+	ErrorConflict         = errors.New("Record is still referenced by other records")
+	ErrorInvalidReference = errors.New("Record refers to a record which does not exist")
+
+	// relations are the relations between collections in use by the server. They are built by buildRelations
+	// when the routes are added.
+	relations = []Relation{}
+)
+
+// validOnDelete returns whether `policy` is one of the OnDelete* policies
+//
+func validOnDelete(policy string) bool {
+	switch policy {
+	case OnDeleteNone, OnDeleteCascade, OnDeleteSetNull, OnDeleteRestrict:
+		return true
+	}
+
+	return false
+}
+
+// buildRelations combines the relations inferred from `data` with the ones declared in the configuration. Inferred
+// relations use the default `config.OnDelete` policy. A declared relation without a parent only overrides the
+// policy of the inferred relation with the same child and foreign key.
+//
+func buildRelations(data BackingData) []Relation {
+	built := inferRelations(data)
+
+	for i := range built {
+		built[i].OnDelete = config.OnDelete
+	}
+
+declaredLoop:
+	for _, declared := range config.Relations {
+		if declared.OnDelete == "" {
+			declared.OnDelete = config.OnDelete
+		}
+
+		for i, relation := range built {
+			if relation.Child == declared.Child && relation.ForeignKey == declared.ForeignKey {
+				if declared.Parent != "" {
+					built[i].Parent = declared.Parent
+				}
+
+				built[i].OnDelete = declared.OnDelete
+				continue declaredLoop
+			}
+		}
+
+		if declared.Parent == "" {
+			logger.Warnf("Relation %s.%s does not refer to any collection\n", declared.Child, declared.ForeignKey)
+			continue
+		}
+
+		built = append(built, declared)
+	}
+
+	return built
+}
+
+// parseRelationPolicies parses relation declarations of the form `child.foreignKey=policy` or
+// `child.foreignKey->parent=policy`, separated by commas
+//
+func parseRelationPolicies(value string) ([]Relation, error) {
+	declared := []Relation{}
+
+	for _, declaration := range strings.Split(value, ",") {
+		declaration = strings.TrimSpace(declaration)
+		if declaration == "" {
+			continue
+		}
+
+		parts := strings.SplitN(declaration, "=", 2)
+		if len(parts) != 2 || !validOnDelete(parts[1]) {
+			return nil, fmt.Errorf("invalid relation %q", declaration)
+		}
+
+		relation := Relation{OnDelete: parts[1]}
+		field := parts[0]
+
+		if arrow := strings.Index(field, "->"); arrow >= 0 {
+			relation.Parent = field[arrow+2:]
+			field = field[:arrow]
+		}
+
+		dot := strings.Index(field, ".")
+		if dot <= 0 || dot == len(field)-1 {
+			return nil, fmt.Errorf("invalid relation %q", declaration)
+		}
+
+		relation.Child, relation.ForeignKey = field[:dot], field[dot+1:]
+		declared = append(declared, relation)
+	}
+
+	return declared, nil
+}
+
+// ValidateRecord checks `record` (a record of `itemType`) against the schema of its collection and then checks its
+// foreign keys (see ValidateReferences)
+//
+func (b BackingData) ValidateRecord(itemType string, record map[string]interface{}) error {
+	if err := validateSchema(itemType, record); err != nil {
+		return err
+	}
+
+	return b.ValidateReferences(itemType, record)
+}
+
+// ValidateReferences checks that every foreign key of `record` (a record of `itemType`) refers to an existing
+// record. Null foreign keys and relations with the OnDeleteNone policy are not checked.
+//
+func (b BackingData) ValidateReferences(itemType string, record map[string]interface{}) error {
+	for _, relation := range relations {
+		if relation.Child != itemType || relation.OnDelete == OnDeleteNone {
+			continue
+		}
+
+		parentId := record[relation.ForeignKey]
+		if parentId == nil {
+			continue
+		}
+
+		if _, err := b.RecordWithId(relation.Parent, parentId); err != nil {
+			return ErrorInvalidReference
+		}
+	}
+
+	return nil
+}
+
+// referencedCollections returns the collections which records of `itemType` refer to, which are read when its
+// foreign keys are validated
+//
+func referencedCollections(itemType string) []string {
+	parents := []string{}
+
+	for _, relation := range relations {
+		if relation.Child == itemType {
+			parents = append(parents, relation.Parent)
+		}
+	}
+
+	return parents
+}
+
+// cascadeCollections returns `itemType` and every collection which deleting a record of `itemType` may modify,
+// following relations from parent to child
+//
+func cascadeCollections(itemType string) []string {
+	affected := []string{itemType}
+	seen := map[string]bool{itemType: true}
+
+	for i := 0; i < len(affected); i++ {
+		for _, relation := range relations {
+			if relation.Parent == affected[i] && !seen[relation.Child] {
+				seen[relation.Child] = true
+				affected = append(affected, relation.Child)
+			}
+		}
+	}
+
+	return affected
+}
+
+// recordRef identifies a single record. `id` is the record's idKey.
+//
+type recordRef struct {
+	itemType string
+	id       string
+}
+
+// DeleteRecordWithRelations deletes a record and applies the OnDelete policy of every relation referring to it.
+// If a restricting relation prevents the record (or any record it cascades to) from being deleted, ErrorConflict
+// is returned and nothing is modified.
+//
+func (b BackingData) DeleteRecordWithRelations(itemType string, id interface{}) error {
+	if _, err := b.RecordWithId(itemType, id); err != nil {
+		return err
+	}
+
+	key, _ := idKey(id)
+	planned := []recordRef{}
+	seen := make(map[recordRef]bool)
+
+	if err := b.planDeletion(recordRef{itemType, key}, &planned, seen); err != nil {
+		return err
+	}
+
+	for _, ref := range planned {
+		for _, relation := range relations {
+			if relation.Parent != ref.itemType || relation.OnDelete != OnDeleteSetNull {
+				continue
+			}
+
+			b.setNullReferences(relation, ref.id)
+		}
+	}
+
+	for _, ref := range planned {
+		b.DeleteRecord(ref.itemType, ref.id)
+	}
+
+	return nil
+}
+
+// planDeletion appends `ref` and every record it cascades to onto `planned`
+//
+func (b BackingData) planDeletion(ref recordRef, planned *[]recordRef, seen map[recordRef]bool) error {
+	if seen[ref] {
+		return nil
+	}
+
+	seen[ref] = true
+	*planned = append(*planned, ref)
+
+	for _, relation := range relations {
+		if relation.Parent != ref.itemType {
+			continue
+		}
+
+		for _, child := range b.referencingRecords(relation, ref.id) {
+			childId, ok := idKey(child[primaryKey(relation.Child)])
+			childRef := recordRef{relation.Child, childId}
+
+			switch relation.OnDelete {
+			case OnDeleteRestrict:
+				if !ok || !seen[childRef] {
+					return ErrorConflict
+				}
+			case OnDeleteCascade:
+				if !ok {
+					continue
+				}
+
+				if err := b.planDeletion(childRef, planned, seen); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// setNullReferences sets the foreign key of the records of `relation.Child` referring to `parentId` to null. The
+// records are replaced rather than modified in place so that the collection's indexes stay up to date.
+//
+func (b BackingData) setNullReferences(relation Relation, parentId interface{}) {
+	collection, err := b.collection(relation.Child)
+	if err != nil {
+		return
+	}
+
+	for i, row := range collection.Rows {
+		child, ok := row.(map[string]interface{})
+		if !ok || !sameId(child[relation.ForeignKey], parentId) {
+			continue
+		}
+
+		updated := make(map[string]interface{}, len(child))
+		for key, value := range child {
+			updated[key] = value
+		}
+
+		updated[relation.ForeignKey] = nil
+		b.replaceRecordAt(relation.Child, i, updated)
+	}
+}
+
+// referencingRecords returns the records of `relation.Child` whose foreign key is `parentId`
+//
+func (b BackingData) referencingRecords(relation Relation, parentId interface{}) []map[string]interface{} {
+	rows, _ := b.ItemType(relation.Child)
+	children := []map[string]interface{}{}
+
+	for _, row := range rows {
+		if child, ok := row.(map[string]interface{}); ok && sameId(child[relation.ForeignKey], parentId) {
+			children = append(children, child)
+		}
+	}
+
+	return children
+}
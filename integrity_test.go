@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func integrityTestData() BackingData {
+	return BackingData{
+		"users": []interface{}{
+			map[string]interface{}{"id": int64(1)},
+		},
+		"posts": []interface{}{
+			map[string]interface{}{"id": int64(1), "userId": int64(1)},
+			map[string]interface{}{"id": int64(2), "userId": int64(1)},
+		},
+		"comments": []interface{}{
+			map[string]interface{}{"id": int64(1), "postId": int64(1)},
+			map[string]interface{}{"id": int64(2), "postId": int64(2)},
+		},
+	}
+}
+
+func withRelations(t *testing.T, testRelations []Relation, test func()) {
+	previous := relations
+	defer func() {
+		relations = previous
+	}()
+
+	relations = testRelations
+	test()
+}
+
+func TestParseRelationPolicies(t *testing.T) {
+	declared, err := parseRelationPolicies("comments.postId=cascade, likes.authorRef->users=restrict")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Relation{
+		Relation{Child: "comments", ForeignKey: "postId", OnDelete: OnDeleteCascade},
+		Relation{Child: "likes", ForeignKey: "authorRef", Parent: "users", OnDelete: OnDeleteRestrict},
+	}
+
+	if !reflect.DeepEqual(declared, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, declared)
+	}
+
+	for _, invalid := range []string{"comments.postId", "comments=cascade", "comments.postId=explode", ".postId=none"} {
+		if _, err := parseRelationPolicies(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestBuildRelations(t *testing.T) {
+	defer withDefaultConfig()()
+
+	config.OnDelete = OnDeleteSetNull
+	config.Relations = []Relation{
+		Relation{Child: "comments", ForeignKey: "postId", OnDelete: OnDeleteCascade},
+		Relation{Child: "comments", ForeignKey: "authorRef", Parent: "users"},
+		Relation{Child: "comments", ForeignKey: "missing"},
+	}
+
+	expected := []Relation{
+		Relation{Child: "comments", Parent: "posts", ForeignKey: "postId", OnDelete: OnDeleteCascade},
+		Relation{Child: "posts", Parent: "users", ForeignKey: "userId", OnDelete: OnDeleteSetNull},
+		Relation{Child: "comments", Parent: "users", ForeignKey: "authorRef", OnDelete: OnDeleteSetNull},
+	}
+
+	if actual := buildRelations(integrityTestData()); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, actual)
+	}
+}
+
+func TestDeleteRecordWithRelations(t *testing.T) {
+	defer disableWal()()
+
+	cascade := []Relation{
+		Relation{Child: "posts", Parent: "users", ForeignKey: "userId", OnDelete: OnDeleteCascade},
+		Relation{Child: "comments", Parent: "posts", ForeignKey: "postId", OnDelete: OnDeleteCascade},
+	}
+
+	withRelations(t, cascade, func() {
+		data := integrityTestData()
+
+		if err := data.DeleteRecordWithRelations("users", 1); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, itemType := range []string{"users", "posts", "comments"} {
+			if rows, _ := data.ItemType(itemType); len(rows) != 0 {
+				t.Errorf("Expected all %s to be deleted, %d remain", itemType, len(rows))
+			}
+		}
+	})
+
+	setNull := []Relation{
+		Relation{Child: "comments", Parent: "posts", ForeignKey: "postId", OnDelete: OnDeleteSetNull},
+	}
+
+	withRelations(t, setNull, func() {
+		data := integrityTestData()
+
+		if err := data.DeleteRecordWithRelations("posts", 1); err != nil {
+			t.Fatal(err)
+		}
+
+		comment, _ := data.RecordWithId("comments", 1)
+		if postId, ok := comment["postId"]; !ok || postId != nil {
+			t.Errorf("Expected postId to be null, got %#v", comment)
+		}
+	})
+
+	restrict := []Relation{
+		Relation{Child: "posts", Parent: "users", ForeignKey: "userId", OnDelete: OnDeleteCascade},
+		Relation{Child: "comments", Parent: "posts", ForeignKey: "postId", OnDelete: OnDeleteRestrict},
+	}
+
+	withRelations(t, restrict, func() {
+		data := integrityTestData()
+
+		if err := data.DeleteRecordWithRelations("users", 1); err != ErrorConflict {
+			t.Errorf("Expected ErrorConflict, got %v", err)
+		}
+
+		// Collections are indexed on first use, so compare the serialized data
+		before, _ := json.Marshal(integrityTestData())
+		after, _ := json.Marshal(data)
+
+		if string(before) != string(after) {
+			t.Error("Nothing should have been modified")
+		}
+
+		if err := data.DeleteRecordWithRelations("users", 9000); err != ErrorNotFound {
+			t.Errorf("Expected ErrorNotFound, got %v", err)
+		}
+	})
+}
+
+func TestValidateReferences(t *testing.T) {
+	testRelations := []Relation{
+		Relation{Child: "comments", Parent: "posts", ForeignKey: "postId", OnDelete: OnDeleteRestrict},
+		Relation{Child: "posts", Parent: "users", ForeignKey: "userId", OnDelete: OnDeleteNone},
+	}
+
+	withRelations(t, testRelations, func() {
+		data := integrityTestData()
+
+		valid := []map[string]interface{}{
+			map[string]interface{}{"postId": int64(2)},
+			map[string]interface{}{"postId": nil},
+			map[string]interface{}{"body": "No post"},
+		}
+
+		for _, record := range valid {
+			if err := data.ValidateReferences("comments", record); err != nil {
+				t.Errorf("Unexpected error for %#v: %s", record, err)
+			}
+		}
+
+		invalid := []map[string]interface{}{
+			map[string]interface{}{"postId": int64(9000)},
+			map[string]interface{}{"postId": true},
+		}
+
+		for _, record := range invalid {
+			if err := data.ValidateReferences("comments", record); err != ErrorInvalidReference {
+				t.Errorf("Expected ErrorInvalidReference for %#v, got %v", record, err)
+			}
+		}
+
+		if err := data.ValidateReferences("posts", map[string]interface{}{"userId": int64(9000)}); err != nil {
+			t.Errorf("Relations without a policy should not be validated, got %s", err)
+		}
+	})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+)
+
+// The data set is protected by two levels of locks. `dataMutex` guards the top level of serverData: it is read
+// locked by every request, and write locked to add, remove or replace top level values (including singular
+// resources). Each collection then has its own lock, so that writes to different collections don't contend.
+//
+// Records are never modified in place once they're part of a collection (see ReplaceRecord), so a record found
+// while holding a collection's lock may still be read after the lock is released.
+
+// lockCollections read locks `dataMutex`, write locks the collections in `write` and read locks the ones in
+// `read`. Names which aren't collections are ignored. The returned function releases every lock.
+//
+func lockCollections(write []string, read []string) func() {
+	dataMutex.RLock()
+
+	writes := make(map[string]bool, len(write)+len(read))
+
+	for _, name := range read {
+		if _, ok := writes[name]; !ok {
+			writes[name] = false
+		}
+	}
+
+	for _, name := range write {
+		writes[name] = true
+	}
+
+	return lockNames(writes)
+}
+
+// lockAllCollections read locks the whole data set, e.g. to serialize it. The returned function releases every lock.
+//
+func lockAllCollections() func() {
+	dataMutex.RLock()
+
+	writes := make(map[string]bool, len(serverData))
+	for name := range serverData {
+		writes[name] = false
+	}
+
+	return lockNames(writes)
+}
+
+// lockNames locks the collections named in `writes` (for writing if the value is true) and returns a function which
+// releases them along with `dataMutex`, which must already be read locked. Collections are always locked in sorted
+// order so that requests which touch several collections can't deadlock.
+//
+func lockNames(writes map[string]bool) func() {
+	names := make([]string, 0, len(writes))
+	for name := range writes {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	unlocks := []func(){dataMutex.RUnlock}
+
+	for _, name := range names {
+		collection, ok := serverData[name].(*Collection)
+		if !ok {
+			continue
+		}
+
+		if writes[name] {
+			collection.mutex.Lock()
+			unlocks = append(unlocks, collection.mutex.Unlock)
+		} else {
+			collection.mutex.RLock()
+			unlocks = append(unlocks, collection.mutex.RUnlock)
+		}
+	}
+
+	return func() {
+		for i := len(unlocks) - 1; i >= 0; i-- {
+			unlocks[i]()
+		}
+	}
+}
+
+// lockForQuery read locks the collections a GET request reads: `itemTypes`, or every collection if the request
+// embeds or expands related records. The returned function releases every lock.
+//
+func lockForQuery(r *http.Request, itemTypes ...string) func() {
+	query := r.URL.Query()
+
+	if query.Get("_embed") != "" || query.Get("_expand") != "" {
+		return lockAllCollections()
+	}
+
+	return lockCollections(nil, itemTypes)
+}
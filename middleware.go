@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/codegangsta/negroni"
+)
+
+var (
+	ErrorReadOnly     = errors.New("The server is read-only")
+	ErrorUnauthorized = errors.New("Valid credentials are required")
+)
+
+// readOnlyMiddleware rejects every request which could change the data with 405 Method Not Allowed. The data may
+// still be flushed.
+//
+func readOnlyMiddleware(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+		next(w, r)
+		return
+	}
+
+	if r.URL.Path == "/_admin/flush" {
+		next(w, r)
+		return
+	}
+
+	w.Header().Set("Allow", "GET, HEAD, OPTIONS")
+	errorJsonResponse(w, r, http.StatusMethodNotAllowed, ErrorReadOnly)
+}
+
+// delayMiddleware waits for `delay` before handling each request, to simulate a slow network
+//
+func delayMiddleware(delay time.Duration) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		time.Sleep(delay)
+		next(w, r)
+	}
+}
+
+// authMiddleware rejects requests without a bearer token or basic auth credentials accepted by `auth` with 401
+// Unauthorized. If `auth.WritesOnly` is set, only requests which could change the data need credentials.
+//
+func authMiddleware(auth AuthConfig) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		if auth.WritesOnly {
+			switch r.Method {
+			case "GET", "HEAD", "OPTIONS":
+				next(w, r)
+				return
+			}
+		}
+
+		if auth.Authorized(r) {
+			next(w, r)
+			return
+		}
+
+		if len(auth.Users) > 0 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="qrest"`)
+		} else {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="qrest"`)
+		}
+
+		errorJsonResponse(w, r, http.StatusUnauthorized, ErrorUnauthorized)
+	}
+}
+
+// Authorized returns whether the request has a bearer token in `auth.Tokens` or the username and password of one of
+// `auth.Users`
+//
+func (auth AuthConfig) Authorized(r *http.Request) bool {
+	if username, password, ok := r.BasicAuth(); ok {
+		expected, exists := auth.Users[username]
+
+		return exists && subtle.ConstantTimeCompare([]byte(password), []byte(expected)) == 1
+	}
+
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+
+	token := []byte(strings.TrimPrefix(header, "Bearer "))
+
+	for _, accepted := range auth.Tokens {
+		if subtle.ConstantTimeCompare(token, []byte(accepted)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadOnlyMiddleware(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{"GET", "/posts", http.StatusOK},
+		{"HEAD", "/posts", http.StatusOK},
+		{"POST", "/posts", http.StatusMethodNotAllowed},
+		{"PUT", "/posts/1", http.StatusMethodNotAllowed},
+		{"PATCH", "/posts/1", http.StatusMethodNotAllowed},
+		{"DELETE", "/posts/1", http.StatusMethodNotAllowed},
+		{"POST", "/_admin/flush", http.StatusOK},
+		{"POST", "/_admin/collections", http.StatusMethodNotAllowed},
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		readOnlyMiddleware(w, httptest.NewRequest(test.method, test.path, nil), ok)
+
+		if w.Code != test.status {
+			t.Errorf("Expected %d for %s %s, got %d", test.status, test.method, test.path, w.Code)
+		}
+	}
+}
+
+func TestDelayMiddleware(t *testing.T) {
+	start := time.Now()
+	called := false
+
+	delayMiddleware(20*time.Millisecond)(httptest.NewRecorder(), httptest.NewRequest("GET", "/posts", nil), func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	if elapsed := time.Since(start); !called || elapsed < 20*time.Millisecond {
+		t.Errorf("Expected the request to be handled after 20ms, got %s (%t)", elapsed, called)
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	auth := AuthConfig{Tokens: []string{"secret"}, Users: map[string]string{"admin": "hunter2"}}
+
+	tests := []struct {
+		method     string
+		token      string
+		username   string
+		password   string
+		writesOnly bool
+		status     int
+	}{
+		{"GET", "", "", "", false, http.StatusUnauthorized},
+		{"GET", "secret", "", "", false, http.StatusOK},
+		{"GET", "wrong", "", "", false, http.StatusUnauthorized},
+		{"POST", "", "admin", "hunter2", false, http.StatusOK},
+		{"POST", "", "admin", "wrong", false, http.StatusUnauthorized},
+		{"GET", "", "", "", true, http.StatusOK},
+		{"DELETE", "", "", "", true, http.StatusUnauthorized},
+	}
+
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/posts", nil)
+		if test.token != "" {
+			r.Header.Set("Authorization", "Bearer "+test.token)
+		}
+
+		if test.username != "" {
+			r.SetBasicAuth(test.username, test.password)
+		}
+
+		auth.WritesOnly = test.writesOnly
+
+		w := httptest.NewRecorder()
+		authMiddleware(auth)(w, r, ok)
+
+		if w.Code != test.status {
+			t.Errorf("Expected %d for %+v, got %d", test.status, test, w.Code)
+		}
+
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("Expected a WWW-Authenticate header for %+v", test)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const defaultPageLimit = 10
+
+var ErrorInvalidCursor = errors.New("Invalid pagination cursor")
+
+// paginateRecords slices `rows` (which must already be filtered and sorted by `sortSpec`, see sortRecords)
+// according to the pagination parameters of the request and sets the `X-Total-Count` header. Three styles are
+// supported:
+//
+//    ?_page=2&_limit=10    (page based, also sets RFC 5988 Link headers)
+//    ?_start=10&_end=20    (or ?_start=10&_limit=10)
+//    ?_cursor=&_limit=10   (keyset based, the Link header's "next" relation holds the next cursor)
+//
+// If none of these parameters are present, all rows are returned.
+//
+func paginateRecords(w http.ResponseWriter, r *http.Request, sortSpec string, idField string, rows []interface{}) ([]interface{}, error) {
+	query := r.URL.Query()
+	total := len(rows)
+
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+
+	if _, ok := query["_cursor"]; ok {
+		return paginateByCursor(w, r, sortSpec, idField, rows)
+	}
+
+	limit, err := queryInt(query, "_limit", -1)
+	if err != nil {
+		return nil, err
+	}
+
+	if query.Get("_start") != "" || query.Get("_end") != "" {
+		start, err := queryInt(query, "_start", 0)
+		if err != nil {
+			return nil, err
+		}
+
+		end, err := queryInt(query, "_end", total)
+		if err != nil {
+			return nil, err
+		}
+
+		if query.Get("_end") == "" && limit >= 0 {
+			end = start + limit
+		}
+
+		return sliceRows(rows, start, end), nil
+	}
+
+	if query.Get("_page") == "" && limit < 0 {
+		return rows, nil
+	}
+
+	page, err := queryInt(query, "_page", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if page < 1 {
+		page = 1
+	}
+
+	if limit < 0 {
+		limit = defaultPageLimit
+	}
+
+	lastPage := 1
+	if limit > 0 && total > 0 {
+		lastPage = (total + limit - 1) / limit
+	}
+
+	links := []string{
+		pageLink(r, 1, limit, "first"),
+	}
+
+	if page > 1 {
+		links = append(links, pageLink(r, page-1, limit, "prev"))
+	}
+
+	if page < lastPage {
+		links = append(links, pageLink(r, page+1, limit, "next"))
+	}
+
+	links = append(links, pageLink(r, lastPage, limit, "last"))
+	w.Header().Set("Link", strings.Join(links, ", "))
+
+	return sliceRows(rows, (page-1)*limit, page*limit), nil
+}
+
+// paginateByCursor returns the page of rows following the position encoded in the `_cursor` parameter. An empty
+// cursor starts at the beginning. Since the cursor holds the sort values of the last row returned rather than
+// an offset, records inserted or deleted between requests don't cause rows to be skipped or repeated. `sortSpec` must
+// be the one `rows` were sorted by, which isn't always `_sort` (see queryRecords).
+//
+func paginateByCursor(w http.ResponseWriter, r *http.Request, sortSpec string, idField string, rows []interface{}) ([]interface{}, error) {
+	query := r.URL.Query()
+	keys := parseSortKeys(sortSpec, idField)
+
+	limit, err := queryInt(query, "_limit", defaultPageLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	start := 0
+
+	if cursor := query.Get("_cursor"); cursor != "" {
+		after, err := decodeCursor(cursor, len(keys))
+		if err != nil {
+			return nil, err
+		}
+
+		start = len(rows)
+		for i, row := range rows {
+			record, _ := row.(map[string]interface{})
+
+			if compareByKeys(sortValues(record, keys), after, keys) > 0 {
+				start = i
+				break
+			}
+		}
+	}
+
+	page := sliceRows(rows, start, start+limit)
+
+	if start+limit < len(rows) && len(page) > 0 {
+		last, _ := page[len(page)-1].(map[string]interface{})
+
+		cursor, err := encodeCursor(sortValues(last, keys))
+		if err != nil {
+			return nil, err
+		}
+
+		w.Header().Set("X-Next-Cursor", cursor)
+		w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, requestUrlWith(r, map[string]string{"_cursor": cursor})))
+	}
+
+	return page, nil
+}
+
+// encodeCursor turns the sort values of a row into an opaque string which can be passed back as `_cursor`
+//
+func encodeCursor(values []interface{}) (string, error) {
+	jsonData, err := json.Marshal(map[string]interface{}{"after": values})
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(jsonData), nil
+}
+
+// decodeCursor reverses encodeCursor. `keyCount` is the number of sort keys for the current request, which must
+// match the number of values in the cursor.
+//
+func decodeCursor(cursor string, keyCount int) ([]interface{}, error) {
+	jsonData, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrorInvalidCursor
+	}
+
+	data := make(map[string]interface{})
+	if err := decodeJson(bytes.NewReader(jsonData), &data); err != nil {
+		return nil, ErrorInvalidCursor
+	}
+
+	values, ok := data["after"].([]interface{})
+	if !ok || len(values) != keyCount {
+		return nil, ErrorInvalidCursor
+	}
+
+	return values, nil
+}
+
+// sliceRows returns rows[start:end], clamping the bounds to the slice
+//
+func sliceRows(rows []interface{}, start, end int) []interface{} {
+	if start < 0 {
+		start = 0
+	}
+
+	if end > len(rows) {
+		end = len(rows)
+	}
+
+	if start >= end {
+		return []interface{}{}
+	}
+
+	return rows[start:end]
+}
+
+// queryInt parses the integer query parameter `key`, returning `fallback` if it is not present
+//
+func queryInt(query url.Values, key string, fallback int) (int, error) {
+	value := query.Get(key)
+	if value == "" {
+		return fallback, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid value for %s: %s", key, value)
+	}
+
+	return number, nil
+}
+
+func pageLink(r *http.Request, page, limit int, rel string) string {
+	overrides := map[string]string{
+		"_page":  strconv.Itoa(page),
+		"_limit": strconv.Itoa(limit),
+	}
+
+	return fmt.Sprintf(`<%s>; rel="%s"`, requestUrlWith(r, overrides), rel)
+}
+
+// requestUrlWith returns the absolute URL of the request with the given query parameters replaced. The route prefix
+// has been removed from the request path by prefixMiddleware, so it is added back.
+//
+func requestUrlWith(r *http.Request, overrides map[string]string) string {
+	query := r.URL.Query()
+	for key, value := range overrides {
+		query.Set(key, value)
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	linkUrl := url.URL{
+		Scheme:   scheme,
+		Host:     r.Host,
+		Path:     config.Prefix + r.URL.Path,
+		RawQuery: query.Encode(),
+	}
+
+	return linkUrl.String()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func paginationTestRows(count int) []interface{} {
+	rows := make([]interface{}, count)
+
+	for i := range rows {
+		rows[i] = map[string]interface{}{"id": int64(i + 1)}
+	}
+
+	return rows
+}
+
+func rowIds(rows []interface{}) []int64 {
+	ids := make([]int64, len(rows))
+
+	for i, row := range rows {
+		ids[i] = row.(map[string]interface{})["id"].(int64)
+	}
+
+	return ids
+}
+
+func idsEqual(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func TestPaginateRecords(t *testing.T) {
+	tests := map[string][]int64{
+		"/posts":                   []int64{1, 2, 3, 4, 5, 6, 7},
+		"/posts?_page=2&_limit=3":  []int64{4, 5, 6},
+		"/posts?_page=3&_limit=3":  []int64{7},
+		"/posts?_page=9&_limit=3":  []int64{},
+		"/posts?_limit=2":          []int64{1, 2},
+		"/posts?_start=2&_end=4":   []int64{3, 4},
+		"/posts?_start=5":          []int64{6, 7},
+		"/posts?_start=1&_limit=2": []int64{2, 3},
+		"/posts?_end=100":          []int64{1, 2, 3, 4, 5, 6, 7},
+	}
+
+	for path, expectedIds := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", path, nil)
+
+		rows, err := paginateRecords(w, r, "", "id", paginationTestRows(7))
+		if err != nil {
+			t.Errorf("%s: unexpected error %s", path, err)
+			continue
+		}
+
+		if ids := rowIds(rows); !idsEqual(ids, expectedIds) {
+			t.Errorf("%s: expected %v, got %v", path, expectedIds, ids)
+		}
+
+		if total := w.Header().Get("X-Total-Count"); total != "7" {
+			t.Errorf("%s: expected X-Total-Count 7, got %q", path, total)
+		}
+	}
+
+	for _, path := range []string{"/posts?_page=abc", "/posts?_limit=-1", "/posts?_cursor=notacursor"} {
+		r := httptest.NewRequest("GET", path, nil)
+		if _, err := paginateRecords(httptest.NewRecorder(), r, "", "id", paginationTestRows(7)); err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+}
+
+func TestPaginationLinks(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "http://example.com/posts?author=Foo&_page=2&_limit=3", nil)
+
+	if _, err := paginateRecords(w, r, "", "id", paginationTestRows(7)); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		`<http://example.com/posts?_limit=3&_page=1&author=Foo>; rel="first"`,
+		`<http://example.com/posts?_limit=3&_page=1&author=Foo>; rel="prev"`,
+		`<http://example.com/posts?_limit=3&_page=3&author=Foo>; rel="next"`,
+		`<http://example.com/posts?_limit=3&_page=3&author=Foo>; rel="last"`,
+	}
+
+	if link := w.Header().Get("Link"); link != strings.Join(expected, ", ") {
+		t.Errorf("Unexpected Link header %s", link)
+	}
+}
+
+func TestCursorPagination(t *testing.T) {
+	rows := paginationTestRows(5)
+	seen := []int64{}
+	path := "/posts?_cursor=&_limit=2"
+
+	for pages := 0; path != ""; pages++ {
+		if pages > 5 {
+			t.Fatal("Cursor pagination did not terminate")
+		}
+
+		w := httptest.NewRecorder()
+		page, err := paginateByCursor(w, httptest.NewRequest("GET", path, nil), "", "id", rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		seen = append(seen, rowIds(page)...)
+
+		// Simulate a concurrent insert after the first page. Since it sorts after every existing row it
+		// should show up exactly once at the end
+		if pages == 0 {
+			rows = append(rows, map[string]interface{}{"id": int64(6)})
+		}
+
+		path = ""
+		if cursor := w.Header().Get("X-Next-Cursor"); cursor != "" {
+			path = "/posts?_limit=2&_cursor=" + cursor
+		}
+	}
+
+	if expected := []int64{1, 2, 3, 4, 5, 6}; !idsEqual(seen, expected) {
+		t.Errorf("Expected %v, got %v", expected, seen)
+	}
+}
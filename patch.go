@@ -0,0 +1,470 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// jsonPatchContentType selects a JSON Patch (RFC 6902) rather than a JSON Merge Patch for PATCH requests
+//
+const jsonPatchContentType = "application/json-patch+json"
+
+var (
+	ErrorInvalidPatch   = errors.New("Invalid JSON Patch document")
+	ErrorInvalidPointer = errors.New("Invalid JSON Pointer")
+	ErrorPathNotFound   = errors.New("Path not present in the document")
+	ErrorTestFailed     = errors.New("Test operation failed")
+	ErrorMoveIntoChild  = errors.New("Can't move a value into one of its children")
+	ErrorPatchNotObject = errors.New("Patched document is not an object")
+)
+
+// patchDocument is the body of a PATCH request, which can be applied to a record or resource
+//
+type patchDocument interface {
+	Apply(document interface{}) (interface{}, error)
+}
+
+// readPatchDocument parses the body of a PATCH request: a JSON Patch if its Content-Type is
+// `application/json-patch+json`, and a JSON Merge Patch otherwise
+//
+func readPatchDocument(r *http.Request) (patchDocument, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == jsonPatchContentType {
+		return readJsonPatch(r)
+	}
+
+	data, err := readRequestData(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return mergePatchDocument{data}, nil
+}
+
+// applyPatch applies `patch` to a record or resource, which must still be an object afterwards. `document` isn't
+// modified.
+//
+func applyPatch(patch patchDocument, document map[string]interface{}) (map[string]interface{}, error) {
+	patched, err := patch.Apply(document)
+	if err != nil {
+		return nil, err
+	}
+
+	patchedObject, ok := patched.(map[string]interface{})
+	if !ok {
+		return nil, ErrorPatchNotObject
+	}
+
+	return patchedObject, nil
+}
+
+// patchErrorStatus returns the status to respond with when a patch can't be applied: 409 Conflict if a JSON Patch
+// test operation failed and 422 Unprocessable Entity for anything else
+//
+func patchErrorStatus(err error) int {
+	if patchErr, ok := err.(*JsonPatchError); ok && patchErr.Err == ErrorTestFailed {
+		return http.StatusConflict
+	}
+
+	return http.StatusUnprocessableEntity
+}
+
+// mergePatchDocument is an RFC 7386 JSON Merge Patch (see mergePatch)
+//
+type mergePatchDocument struct {
+	patch map[string]interface{}
+}
+
+func (p mergePatchDocument) Apply(document interface{}) (interface{}, error) {
+	return mergePatch(document, p.patch), nil
+}
+
+// mergePatch applies an RFC 7386 JSON Merge Patch to `target` and returns the result: objects in the patch are
+// merged into the corresponding objects of the target recursively, null removes a field and any other value
+// replaces it. `target` isn't modified, since records are never changed in place.
+//
+func mergePatch(target interface{}, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, _ := target.(map[string]interface{})
+
+	merged := make(map[string]interface{}, len(targetObject)+len(patchObject))
+	for key, value := range targetObject {
+		merged[key] = value
+	}
+
+	for key, value := range patchObject {
+		if value == nil {
+			delete(merged, key)
+			continue
+		}
+
+		merged[key] = mergePatch(merged[key], value)
+	}
+
+	return merged
+}
+
+// jsonPatch is an RFC 6902 JSON Patch, a list of operations which are applied in order. If any of them fails, none
+// of them are applied.
+//
+type jsonPatch []jsonPatchOperation
+
+// jsonPatchOperation is a single operation of a JSON Patch. `path` and `from` are JSON Pointers (RFC 6901) which have
+// already been split into their reference tokens.
+//
+type jsonPatchOperation struct {
+	op    string
+	path  []string
+	from  []string
+	value interface{}
+}
+
+// JsonPatchError describes the operation of a JSON Patch which couldn't be applied
+//
+type JsonPatchError struct {
+	Index int
+	Op    string
+	Path  string
+	Err   error
+}
+
+func (e *JsonPatchError) Error() string {
+	return fmt.Sprintf("%s (operation %d, %s %s)", e.Err, e.Index, e.Op, e.Path)
+}
+
+// readJsonPatch parses a JSON Patch from the body of a request. Every operation is checked before any of them are
+// applied, so a malformed patch is rejected as a whole.
+//
+func readJsonPatch(r *http.Request) (jsonPatch, error) {
+	var document interface{}
+	if err := decodeJson(r.Body, &document); err != nil {
+		return nil, err
+	}
+
+	operations, ok := convertMapType(document).([]interface{})
+	if !ok {
+		return nil, ErrorInvalidPatch
+	}
+
+	patch := make(jsonPatch, len(operations))
+
+	for i, operation := range operations {
+		fields, ok := operation.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: operation %d is not an object", ErrorInvalidPatch, i)
+		}
+
+		op, _ := fields["op"].(string)
+		path, ok := fields["path"].(string)
+		if !ok {
+			return nil, fmt.Errorf("%s: operation %d has no path", ErrorInvalidPatch, i)
+		}
+
+		parsed := jsonPatchOperation{op: op}
+
+		var err error
+		if parsed.path, err = parsePointer(path); err != nil {
+			return nil, fmt.Errorf("%s: operation %d: %s %q", ErrorInvalidPatch, i, err, path)
+		}
+
+		switch op {
+		case "add", "replace", "test":
+			value, ok := fields["value"]
+			if !ok {
+				return nil, fmt.Errorf("%s: operation %d (%s) has no value", ErrorInvalidPatch, i, op)
+			}
+
+			parsed.value = value
+		case "move", "copy":
+			from, ok := fields["from"].(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: operation %d (%s) has no from", ErrorInvalidPatch, i, op)
+			}
+
+			if parsed.from, err = parsePointer(from); err != nil {
+				return nil, fmt.Errorf("%s: operation %d: %s %q", ErrorInvalidPatch, i, err, from)
+			}
+		case "remove":
+		default:
+			return nil, fmt.Errorf("%s: operation %d has unknown op %q", ErrorInvalidPatch, i, op)
+		}
+
+		patch[i] = parsed
+	}
+
+	return patch, nil
+}
+
+// Apply applies the operations of the patch to a copy of `document`
+//
+func (p jsonPatch) Apply(document interface{}) (interface{}, error) {
+	document = copyInterfaceType(document)
+
+	for i, operation := range p {
+		var err error
+		if document, err = operation.apply(document); err != nil {
+			return nil, &JsonPatchError{Index: i, Op: operation.op, Path: formatPointer(operation.path), Err: err}
+		}
+	}
+
+	return document, nil
+}
+
+// apply applies the operation to `document` (which may be modified) and returns the result
+//
+func (o jsonPatchOperation) apply(document interface{}) (interface{}, error) {
+	switch o.op {
+	case "add":
+		return pointerAdd(document, o.path, copyInterfaceType(o.value))
+	case "remove":
+		return pointerRemove(document, o.path)
+	case "replace":
+		if _, err := pointerGet(document, o.path); err != nil {
+			return nil, err
+		}
+
+		return pointerSet(document, o.path, copyInterfaceType(o.value))
+	case "move":
+		if len(o.path) > len(o.from) && formatPointer(o.path[:len(o.from)]) == formatPointer(o.from) {
+			return nil, ErrorMoveIntoChild
+		}
+
+		value, err := pointerGet(document, o.from)
+		if err != nil {
+			return nil, err
+		}
+
+		if document, err = pointerRemove(document, o.from); err != nil {
+			return nil, err
+		}
+
+		return pointerAdd(document, o.path, value)
+	case "copy":
+		value, err := pointerGet(document, o.from)
+		if err != nil {
+			return nil, err
+		}
+
+		return pointerAdd(document, o.path, copyInterfaceType(value))
+	case "test":
+		value, err := pointerGet(document, o.path)
+		if err != nil {
+			return nil, err
+		}
+
+		if !jsonEqual(value, o.value) {
+			return nil, ErrorTestFailed
+		}
+
+		return document, nil
+	}
+
+	return nil, ErrorInvalidPatch
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into its unescaped reference tokens. The empty pointer refers to the
+// whole document.
+//
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, ErrorInvalidPointer
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+	}
+
+	return tokens, nil
+}
+
+// formatPointer is the inverse of parsePointer
+//
+func formatPointer(tokens []string) string {
+	var pointer bytes.Buffer
+	for _, token := range tokens {
+		pointer.WriteString("/")
+		pointer.WriteString(strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1))
+	}
+
+	return pointer.String()
+}
+
+// arrayIndex parses a reference token as an index of an array of `length` elements. "-" (the element after the last)
+// is only accepted if `allowEnd` is set, as are indexes up to `length`.
+//
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return length, nil
+	}
+
+	// Leading zeros and signs aren't allowed
+	if token == "" || (len(token) > 1 && token[0] == '0') || strings.TrimLeft(token, "0123456789") != "" {
+		return 0, ErrorInvalidPointer
+	}
+
+	index, err := strconv.Atoi(token)
+	if err != nil || index > length || (index == length && !allowEnd) {
+		return 0, ErrorPathNotFound
+	}
+
+	return index, nil
+}
+
+// pointerGet returns the value `tokens` refers to
+//
+func pointerGet(document interface{}, tokens []string) (interface{}, error) {
+	for _, token := range tokens {
+		switch value := document.(type) {
+		case map[string]interface{}:
+			child, ok := value[token]
+			if !ok {
+				return nil, ErrorPathNotFound
+			}
+
+			document = child
+		case []interface{}:
+			index, err := arrayIndex(token, len(value), false)
+			if err != nil {
+				return nil, err
+			}
+
+			document = value[index]
+		default:
+			return nil, ErrorPathNotFound
+		}
+	}
+
+	return document, nil
+}
+
+// pointerSet replaces the value `tokens` refers to, or adds a member to an object. It returns the updated document.
+//
+func pointerSet(document interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	parent, err := pointerGet(document, tokens[:len(tokens)-1])
+	if err != nil {
+		return nil, err
+	}
+
+	last := tokens[len(tokens)-1]
+
+	switch parent := parent.(type) {
+	case map[string]interface{}:
+		parent[last] = value
+	case []interface{}:
+		index, err := arrayIndex(last, len(parent), false)
+		if err != nil {
+			return nil, err
+		}
+
+		parent[index] = value
+	default:
+		return nil, ErrorPathNotFound
+	}
+
+	return document, nil
+}
+
+// pointerAdd adds `value` at `tokens`, inserting it into an array or adding (or replacing) a member of an object. It
+// returns the updated document.
+//
+func pointerAdd(document interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	parentTokens := tokens[:len(tokens)-1]
+	parent, err := pointerGet(document, parentTokens)
+	if err != nil {
+		return nil, err
+	}
+
+	array, ok := parent.([]interface{})
+	if !ok {
+		return pointerSet(document, tokens, value)
+	}
+
+	index, err := arrayIndex(tokens[len(tokens)-1], len(array), true)
+	if err != nil {
+		return nil, err
+	}
+
+	inserted := make([]interface{}, 0, len(array)+1)
+	inserted = append(inserted, array[:index]...)
+	inserted = append(inserted, value)
+	inserted = append(inserted, array[index:]...)
+
+	return pointerSet(document, parentTokens, inserted)
+}
+
+// pointerRemove removes the value `tokens` refers to and returns the updated document. The whole document can't be
+// removed.
+//
+func pointerRemove(document interface{}, tokens []string) (interface{}, error) {
+	if len(tokens) == 0 {
+		return nil, ErrorPathNotFound
+	}
+
+	parentTokens := tokens[:len(tokens)-1]
+	parent, err := pointerGet(document, parentTokens)
+	if err != nil {
+		return nil, err
+	}
+
+	last := tokens[len(tokens)-1]
+
+	switch parent := parent.(type) {
+	case map[string]interface{}:
+		if _, ok := parent[last]; !ok {
+			return nil, ErrorPathNotFound
+		}
+
+		delete(parent, last)
+		return document, nil
+	case []interface{}:
+		index, err := arrayIndex(last, len(parent), false)
+		if err != nil {
+			return nil, err
+		}
+
+		removed := make([]interface{}, 0, len(parent)-1)
+		removed = append(removed, parent[:index]...)
+		removed = append(removed, parent[index+1:]...)
+
+		return pointerSet(document, parentTokens, removed)
+	}
+
+	return nil, ErrorPathNotFound
+}
+
+// jsonEqual compares two values by their JSON encoding, so that e.g. 1 and 1.0 are equal and the order of the members
+// of objects doesn't matter
+//
+func jsonEqual(a interface{}, b interface{}) bool {
+	encodedA, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+
+	encodedB, err := json.Marshal(b)
+
+	return err == nil && bytes.Equal(encodedA, encodedB)
+}
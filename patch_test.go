@@ -0,0 +1,186 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	// Examples from RFC 7386, appendix A
+	tests := []struct {
+		target   string
+		patch    string
+		expected string
+	}{
+		{`{"a": "b"}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "b"}`, `{"b": "c"}`, `{"a": "b", "b": "c"}`},
+		{`{"a": "b"}`, `{"a": null}`, `{}`},
+		{`{"a": "b", "b": "c"}`, `{"a": null}`, `{"b": "c"}`},
+		{`{"a": ["b"]}`, `{"a": "c"}`, `{"a": "c"}`},
+		{`{"a": "c"}`, `{"a": ["b"]}`, `{"a": ["b"]}`},
+		{`{"a": {"b": "c"}}`, `{"a": {"b": "d", "c": null}}`, `{"a": {"b": "d"}}`},
+		{`{"a": [{"b": "c"}]}`, `{"a": [1]}`, `{"a": [1]}`},
+		{`["a", "b"]`, `["c", "d"]`, `["c", "d"]`},
+		{`{"a": "b"}`, `["c"]`, `["c"]`},
+		{`{"a": "foo"}`, `null`, `null`},
+		{`{"a": "foo"}`, `"bar"`, `"bar"`},
+		{`{"e": null}`, `{"a": 1}`, `{"e": null, "a": 1}`},
+		{`[1, 2]`, `{"a": "b", "c": null}`, `{"a": "b"}`},
+		{`{}`, `{"a": {"bb": {"ccc": null}}}`, `{"a": {"bb": {}}}`},
+	}
+
+	for _, test := range tests {
+		var target, patch, expected interface{}
+		for _, document := range []struct {
+			json  string
+			value *interface{}
+		}{{test.target, &target}, {test.patch, &patch}, {test.expected, &expected}} {
+			if err := decodeJson(strings.NewReader(document.json), document.value); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		targetBefore := copyInterfaceType(target)
+
+		if merged := mergePatch(target, patch); !reflect.DeepEqual(merged, expected) {
+			t.Errorf("Merging %s into %s: expected %#v, got %#v", test.patch, test.target, expected, merged)
+		}
+
+		if !reflect.DeepEqual(target, targetBefore) {
+			t.Errorf("Merging %s modified the target %s", test.patch, test.target)
+		}
+	}
+}
+
+func TestJsonPatch(t *testing.T) {
+	// Examples from RFC 6902, appendix A
+	tests := []struct {
+		document string
+		patch    string
+		expected string
+		err      error
+	}{
+		{`{"foo": "bar"}`, `[{"op": "add", "path": "/baz", "value": "qux"}]`, `{"baz": "qux", "foo": "bar"}`, nil},
+		{`{"foo": ["bar", "baz"]}`, `[{"op": "add", "path": "/foo/1", "value": "qux"}]`, `{"foo": ["bar", "qux", "baz"]}`, nil},
+		{`{"baz": "qux", "foo": "bar"}`, `[{"op": "remove", "path": "/baz"}]`, `{"foo": "bar"}`, nil},
+		{`{"foo": ["bar", "qux", "baz"]}`, `[{"op": "remove", "path": "/foo/1"}]`, `{"foo": ["bar", "baz"]}`, nil},
+		{`{"baz": "qux", "foo": "bar"}`, `[{"op": "replace", "path": "/baz", "value": "boo"}]`, `{"baz": "boo", "foo": "bar"}`, nil},
+		{
+			`{"foo": {"bar": "baz", "waldo": "fred"}, "qux": {"corge": "grault"}}`,
+			`[{"op": "move", "from": "/foo/waldo", "path": "/qux/thud"}]`,
+			`{"foo": {"bar": "baz"}, "qux": {"corge": "grault", "thud": "fred"}}`,
+			nil,
+		},
+		{`{"foo": ["all", "grass", "cows", "eat"]}`, `[{"op": "move", "from": "/foo/1", "path": "/foo/3"}]`, `{"foo": ["all", "cows", "eat", "grass"]}`, nil},
+		{
+			`{"baz": "qux", "foo": ["a", 2, "c"]}`,
+			`[{"op": "test", "path": "/baz", "value": "qux"}, {"op": "test", "path": "/foo/1", "value": 2}]`,
+			`{"baz": "qux", "foo": ["a", 2, "c"]}`,
+			nil,
+		},
+		{`{"baz": "qux"}`, `[{"op": "test", "path": "/baz", "value": "bar"}]`, ``, ErrorTestFailed},
+		{`{"foo": "bar"}`, `[{"op": "add", "path": "/child", "value": {"grandchild": {}}}]`, `{"foo": "bar", "child": {"grandchild": {}}}`, nil},
+		{`{"foo": "bar"}`, `[{"op": "add", "path": "/baz/bat", "value": "qux"}]`, ``, ErrorPathNotFound},
+		{`{"/": 9, "~1": 10}`, `[{"op": "test", "path": "/~01", "value": 10}]`, `{"/": 9, "~1": 10}`, nil},
+		{`{"/": 9, "~1": 10}`, `[{"op": "test", "path": "/~01", "value": "10"}]`, ``, ErrorTestFailed},
+		{`{"foo": ["bar"]}`, `[{"op": "add", "path": "/foo/-", "value": ["abc", "def"]}]`, `{"foo": ["bar", ["abc", "def"]]}`, nil},
+
+		{`{"foo": ["bar"]}`, `[{"op": "add", "path": "/foo/2", "value": "baz"}]`, ``, ErrorPathNotFound},
+		{`{"foo": ["bar"]}`, `[{"op": "remove", "path": "/foo/01"}]`, ``, ErrorInvalidPointer},
+		{`{"foo": "bar"}`, `[{"op": "replace", "path": "/baz", "value": "qux"}]`, ``, ErrorPathNotFound},
+		{`{"foo": {"bar": 1}}`, `[{"op": "move", "from": "/foo", "path": "/foo/baz"}]`, ``, ErrorMoveIntoChild},
+		{`{"foo": {"bar": 1}}`, `[{"op": "copy", "from": "/foo", "path": "/baz"}]`, `{"foo": {"bar": 1}, "baz": {"bar": 1}}`, nil},
+		{`{"foo": 1.0}`, `[{"op": "test", "path": "/foo", "value": 1}]`, `{"foo": 1}`, nil},
+		{`{"foo": "bar"}`, `[{"op": "replace", "path": "", "value": {"baz": 1}}]`, `{"baz": 1}`, nil},
+	}
+
+	for _, test := range tests {
+		document := decodeJsonDocument(t, test.document)
+		documentBefore := copyInterfaceType(document)
+
+		request, _ := http.NewRequest("PATCH", "/", strings.NewReader(test.patch))
+		request.Header.Set("Content-Type", jsonPatchContentType)
+
+		patch, err := readPatchDocument(request)
+		if err != nil {
+			t.Errorf("Reading %s: %s", test.patch, err)
+			continue
+		}
+
+		patched, err := patch.Apply(document)
+
+		if test.err != nil {
+			if patchErr, ok := err.(*JsonPatchError); !ok || patchErr.Err != test.err {
+				t.Errorf("Applying %s to %s: expected %q, got %v", test.patch, test.document, test.err, err)
+			}
+		} else if err != nil {
+			t.Errorf("Applying %s to %s: %s", test.patch, test.document, err)
+		} else if expected := decodeJsonDocument(t, test.expected); !jsonEqual(patched, expected) {
+			t.Errorf("Applying %s to %s: expected %s, got %#v", test.patch, test.document, test.expected, patched)
+		}
+
+		if !reflect.DeepEqual(document, documentBefore) {
+			t.Errorf("Applying %s modified the document %s", test.patch, test.document)
+		}
+	}
+}
+
+func TestReadJsonPatchInvalid(t *testing.T) {
+	patches := []string{
+		`{"op": "add", "path": "/foo", "value": 1}`,
+		`[{"op": "add", "path": "/foo"}]`,
+		`[{"op": "move", "path": "/foo"}]`,
+		`[{"op": "add", "path": "foo", "value": 1}]`,
+		`[{"op": "rename", "path": "/foo"}]`,
+		`[{"path": "/foo"}]`,
+		`[1]`,
+	}
+
+	for _, patch := range patches {
+		request, _ := http.NewRequest("PATCH", "/", strings.NewReader(patch))
+
+		if _, err := readJsonPatch(request); err == nil {
+			t.Errorf("Expected %s to be invalid", patch)
+		}
+	}
+}
+
+func TestParsePointer(t *testing.T) {
+	pointers := map[string][]string{
+		"":       []string{},
+		"/":      []string{""},
+		"/foo/0": []string{"foo", "0"},
+		"/a~1b":  []string{"a/b"},
+		"/m~0n":  []string{"m~n"},
+		"/~01":   []string{"~1"},
+		"/ /c%d": []string{" ", "c%d"},
+	}
+
+	for pointer, expected := range pointers {
+		tokens, err := parsePointer(pointer)
+		if err != nil || !reflect.DeepEqual(tokens, expected) {
+			t.Errorf("Expected %q to be parsed as %q, got %q (%v)", pointer, expected, tokens, err)
+		}
+
+		if formatted := formatPointer(tokens); formatted != pointer {
+			t.Errorf("Expected %q to be formatted as %q, got %q", expected, pointer, formatted)
+		}
+	}
+
+	if _, err := parsePointer("foo"); err != ErrorInvalidPointer {
+		t.Errorf("Expected a pointer without a leading / to be invalid, got %v", err)
+	}
+}
+
+// decodeJsonDocument decodes a JSON value the same way as the request data
+//
+func decodeJsonDocument(t *testing.T, document string) interface{} {
+	var value interface{}
+	if err := decodeJson(strings.NewReader(document), &value); err != nil {
+		t.Fatal(err)
+	}
+
+	return convertMapType(value)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// fieldTree is a set of dotted field paths arranged as a tree, e.g. `id,author.name,author.email` becomes
+//
+//    {"id": {}, "author": {"name": {}, "email": {}}}
+//
+// An empty subtree means the whole value at that path is selected.
+//
+type fieldTree map[string]fieldTree
+
+// projection holds the `_fields` and `_exclude` parameters of a request
+//
+type projection struct {
+	include fieldTree
+	exclude fieldTree
+}
+
+// parseFieldTree builds a fieldTree out of comma separated values for a query parameter. It returns nil
+// if no fields were given.
+//
+func parseFieldTree(values []string) fieldTree {
+	var tree fieldTree
+
+	for _, value := range values {
+		for _, path := range strings.Split(value, ",") {
+			path = strings.TrimSpace(path)
+			if path == "" {
+				continue
+			}
+
+			if tree == nil {
+				tree = make(fieldTree)
+			}
+
+			node := tree
+			for _, segment := range strings.Split(path, ".") {
+				child, ok := node[segment]
+				if !ok {
+					child = make(fieldTree)
+					node[segment] = child
+				}
+
+				node = child
+			}
+		}
+	}
+
+	return tree
+}
+
+func parseProjection(query url.Values) projection {
+	return projection{
+		include: parseFieldTree(query["_fields"]),
+		exclude: parseFieldTree(query["_exclude"]),
+	}
+}
+
+// Empty returns whether the projection leaves records untouched
+//
+func (p projection) Empty() bool {
+	return p.include == nil && p.exclude == nil
+}
+
+// Apply returns the projected version of `value`. The stored data is never modified; any objects which need
+// to change are copied.
+//
+func (p projection) Apply(value interface{}) interface{} {
+	if p.include != nil {
+		value, _ = includeFields(value, p.include)
+	}
+
+	if p.exclude != nil {
+		value = excludeFields(value, p.exclude)
+	}
+
+	return value
+}
+
+// ApplyAll projects every row of a collection
+//
+func (p projection) ApplyAll(rows []interface{}) []interface{} {
+	if p.Empty() {
+		return rows
+	}
+
+	projected := make([]interface{}, len(rows))
+	for i, row := range rows {
+		projected[i] = p.Apply(row)
+	}
+
+	return projected
+}
+
+// includeFields returns only the parts of `value` selected by `tree`. Arrays are projected element-wise. The
+// second return value is false if nothing in `value` was selected.
+//
+func includeFields(value interface{}, tree fieldTree) (interface{}, bool) {
+	if len(tree) == 0 {
+		return value, true
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(tree))
+
+		for key, subtree := range tree {
+			child, ok := value[key]
+			if !ok {
+				continue
+			}
+
+			if child, ok = includeFields(child, subtree); ok {
+				result[key] = child
+			}
+		}
+
+		return result, true
+	case []interface{}:
+		result := make([]interface{}, 0, len(value))
+
+		for _, element := range value {
+			if element, ok := includeFields(element, tree); ok {
+				result = append(result, element)
+			}
+		}
+
+		return result, true
+	}
+
+	return nil, false
+}
+
+// excludeFields returns a copy of `value` without the parts selected by `tree`
+//
+func excludeFields(value interface{}, tree fieldTree) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(value))
+
+		for key, child := range value {
+			subtree, ok := tree[key]
+
+			switch {
+			case !ok:
+				result[key] = child
+			case len(subtree) > 0:
+				result[key] = excludeFields(child, subtree)
+			}
+		}
+
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(value))
+
+		for i, element := range value {
+			result[i] = excludeFields(element, tree)
+		}
+
+		return result
+	}
+
+	return value
+}
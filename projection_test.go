@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestProjection(t *testing.T) {
+	newRecord := func() map[string]interface{} {
+		return map[string]interface{}{
+			"id":    int64(1),
+			"title": "Foo",
+			"author": map[string]interface{}{
+				"name":  "Bar",
+				"email": "bar@example.com",
+			},
+			"comments": []interface{}{
+				map[string]interface{}{"id": int64(1), "body": "Baz"},
+			},
+		}
+	}
+
+	tests := map[string]map[string]interface{}{
+		"_fields=id,title": map[string]interface{}{
+			"id":    int64(1),
+			"title": "Foo",
+		},
+		"_fields=id&_fields=author.name,missing,title.nested": map[string]interface{}{
+			"id":     int64(1),
+			"author": map[string]interface{}{"name": "Bar"},
+		},
+		"_fields=comments.body": map[string]interface{}{
+			"comments": []interface{}{map[string]interface{}{"body": "Baz"}},
+		},
+		"_exclude=author.email,comments": map[string]interface{}{
+			"id":     int64(1),
+			"title":  "Foo",
+			"author": map[string]interface{}{"name": "Bar"},
+		},
+		"_fields=id,author&_exclude=author.email": map[string]interface{}{
+			"id":     int64(1),
+			"author": map[string]interface{}{"name": "Bar"},
+		},
+		"": newRecord(),
+	}
+
+	for rawQuery, expected := range tests {
+		query, _ := url.ParseQuery(rawQuery)
+		record := newRecord()
+
+		if actual := parseProjection(query).Apply(record); !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Query %q: expected %#v, got %#v", rawQuery, expected, actual)
+		}
+
+		if !reflect.DeepEqual(record, newRecord()) {
+			t.Errorf("Query %q: the original record was modified", rawQuery)
+		}
+	}
+}
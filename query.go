@@ -0,0 +1,542 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// reservedQueryParams are query string keys which control how a collection is returned rather than
+// filtering on a field
+//
+var reservedQueryParams = map[string]bool{
+	"_sort":    true,
+	"_page":    true,
+	"_limit":   true,
+	"_start":   true,
+	"_end":     true,
+	"_cursor":  true,
+	"q":        true,
+	"q_fields": true,
+	"q_score":  true,
+	"_fields":  true,
+	"_exclude": true,
+	"_embed":   true,
+	"_expand":  true,
+}
+
+// queryRecords applies the filtering, search, sorting, pagination, relation and projection parameters of a
+// collection GET to `rows` (the records of `itemType`), returning the records which should be sent in the response
+//
+func queryRecords(w http.ResponseWriter, r *http.Request, itemType string, rows []interface{}) ([]interface{}, error) {
+	query := r.URL.Query()
+
+	rows, err := filterRecords(rows, query)
+	if err != nil {
+		return nil, err
+	}
+
+	rows = searchRecords(rows, query)
+
+	// Search results are ordered by relevance unless another order is asked for. The same spec is used to build
+	// cursors, which must follow the order the rows are in.
+	sortSpec := query.Get("_sort")
+	if sortSpec == "" && query.Get("q_score") == "true" {
+		sortSpec = "-_score"
+	}
+
+	// Cursors are positions within the sort order, so cursor pagination always needs sorted rows
+	if _, hasCursor := query["_cursor"]; sortSpec != "" || hasCursor {
+		sortRecords(rows, sortSpec, primaryKey(itemType))
+	}
+
+	rows, err = paginateRecords(w, r, sortSpec, primaryKey(itemType), rows)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err = parseRelationQuery(query).ApplyAll(serverData, itemType, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseProjection(query).ApplyAll(rows), nil
+}
+
+// numberPattern matches the numbers which may be compared against stored numbers
+//
+var numberPattern = regexp.MustCompile(`^-?\d+(\.\d+)?([eE][+-]?\d+)?$`)
+
+// Operators which may be appended to a query key, e.g. `?views_lt=100`. A key without a suffix tests for equality.
+//
+const (
+	opEqual          = ""
+	opGreater        = "_gt"
+	opGreaterOrEqual = "_gte"
+	opLess           = "_lt"
+	opLessOrEqual    = "_lte"
+	opNotEqual       = "_ne"
+	opIn             = "_in"
+	opLike           = "_like"
+	opExists         = "_exists"
+)
+
+var filterOperators = []string{opGreaterOrEqual, opGreater, opLessOrEqual, opLess, opNotEqual, opIn, opLike, opExists}
+
+// recordFilter is a single condition parsed from the query string of a collection GET, such as
+// `?author=Foo`, `?meta.lang=en` or `?views_lt=100`
+//
+type recordFilter struct {
+	Path     string
+	Operator string
+	Values   []string
+
+	patterns []*regexp.Regexp
+	exists   bool
+}
+
+// parseFilters builds the list of filters present in a collection GET's query string. Reserved keys are skipped.
+// An error is returned if an operator's value is malformed (e.g. an invalid `_like` pattern).
+//
+func parseFilters(query url.Values) ([]recordFilter, error) {
+	filters := make([]recordFilter, 0, len(query))
+
+	for key, values := range query {
+		if reservedQueryParams[key] {
+			continue
+		}
+
+		filter := recordFilter{Path: key, Operator: opEqual, Values: values}
+
+		for _, operator := range filterOperators {
+			if strings.HasSuffix(key, operator) && len(key) > len(operator) {
+				filter.Path = strings.TrimSuffix(key, operator)
+				filter.Operator = operator
+				break
+			}
+		}
+
+		switch filter.Operator {
+		case opIn:
+			filter.Values = nil
+			for _, value := range values {
+				filter.Values = append(filter.Values, strings.Split(value, ",")...)
+			}
+		case opLike:
+			for _, value := range values {
+				pattern, err := regexp.Compile(value)
+				if err != nil {
+					return nil, fmt.Errorf("invalid pattern for %s: %s", key, err)
+				}
+
+				filter.patterns = append(filter.patterns, pattern)
+			}
+		case opExists:
+			exists, err := strconv.ParseBool(values[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for %s: %s", key, values[0])
+			}
+
+			filter.exists = exists
+		}
+
+		filters = append(filters, filter)
+	}
+
+	return filters, nil
+}
+
+// Matches returns whether `record` satisfies the filter. When an equality, `_in` or `_like` key is given
+// multiple times any of the values may match, whereas `_ne` and range operators must hold for all of them.
+//
+func (f recordFilter) Matches(record map[string]interface{}) bool {
+	stored, ok := lookupPath(record, f.Path)
+
+	switch f.Operator {
+	case opExists:
+		return ok == f.exists
+	case opNotEqual:
+		for _, raw := range f.Values {
+			if value, coerced := coerceQueryValue(raw, stored); ok && coerced && valuesEqual(stored, value) {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	if !ok {
+		return false
+	}
+
+	switch f.Operator {
+	case opEqual, opIn:
+		for _, raw := range f.Values {
+			value, ok := coerceQueryValue(raw, stored)
+
+			if ok && valuesEqual(stored, value) {
+				return true
+			}
+		}
+	case opLike:
+		text := stringValue(stored)
+
+		for _, pattern := range f.patterns {
+			if pattern.MatchString(text) {
+				return true
+			}
+		}
+	case opGreater, opGreaterOrEqual, opLess, opLessOrEqual:
+		for _, raw := range f.Values {
+			value, ok := coerceQueryValue(raw, stored)
+			if !ok {
+				return false
+			}
+
+			comparison, ok := compareValues(stored, value)
+			if !ok {
+				return false
+			}
+
+			switch {
+			case f.Operator == opGreater && comparison <= 0,
+				f.Operator == opGreaterOrEqual && comparison < 0,
+				f.Operator == opLess && comparison >= 0,
+				f.Operator == opLessOrEqual && comparison > 0:
+				return false
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// filterRecords returns the rows which match every filter in `query`. The returned slice is never nil so that
+// it is always serialized as a JSON array.
+//
+func filterRecords(rows []interface{}, query url.Values) ([]interface{}, error) {
+	filters, err := parseFilters(query)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]interface{}, 0, len(rows))
+
+rowLoop:
+	for _, row := range rows {
+		record, ok := row.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		for _, filter := range filters {
+			if !filter.Matches(record) {
+				continue rowLoop
+			}
+		}
+
+		matches = append(matches, row)
+	}
+
+	return matches, nil
+}
+
+// sortKey is a single field of a `?_sort=title,-id` parameter
+//
+type sortKey struct {
+	Path       string
+	Descending bool
+}
+
+// parseSortKeys parses the value of `_sort`. Keys prefixed with `-` are sorted in descending order. The record ID
+// field, `idField`, is always appended as the final key (unless already present) so that the ordering is stable.
+//
+func parseSortKeys(spec string, idField string) []sortKey {
+	keys := []sortKey{}
+	hasId := false
+
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		key := sortKey{Path: strings.TrimPrefix(field, "-"), Descending: strings.HasPrefix(field, "-")}
+
+		if key.Path == "" {
+			continue
+		}
+
+		if key.Path == idField {
+			hasId = true
+		}
+
+		keys = append(keys, key)
+	}
+
+	if !hasId {
+		keys = append(keys, sortKey{Path: idField})
+	}
+
+	return keys
+}
+
+// sortRecords sorts `rows` in place according to a `_sort` spec. If spec is empty, rows are ordered by their ID
+// field, `idField`.
+//
+func sortRecords(rows []interface{}, spec string, idField string) {
+	keys := parseSortKeys(spec, idField)
+
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, _ := rows[i].(map[string]interface{})
+		b, _ := rows[j].(map[string]interface{})
+
+		return compareByKeys(sortValues(a, keys), sortValues(b, keys), keys) < 0
+	})
+}
+
+// sortValues returns the values of `record` for each of the sort keys. Missing fields are nil.
+//
+func sortValues(record map[string]interface{}, keys []sortKey) []interface{} {
+	values := make([]interface{}, len(keys))
+
+	for i, key := range keys {
+		values[i], _ = lookupPath(record, key.Path)
+	}
+
+	return values
+}
+
+// compareByKeys compares two lists of values returned by sortValues, honouring the direction of each key
+//
+func compareByKeys(a, b []interface{}, keys []sortKey) int {
+	for i, key := range keys {
+		comparison := compareSortValues(a[i], b[i])
+		if comparison == 0 {
+			continue
+		}
+
+		if key.Descending {
+			return -comparison
+		}
+
+		return comparison
+	}
+
+	return 0
+}
+
+// compareSortValues orders any two values. Values of different types are ordered null < bool < number <
+// string < everything else, and values of the same type are compared with compareValues. Missing fields
+// are treated as null.
+//
+func compareSortValues(a, b interface{}) int {
+	aRank, bRank := sortTypeRank(a), sortTypeRank(b)
+
+	if aRank != bRank {
+		return aRank - bRank
+	}
+
+	comparison, _ := compareValues(a, b)
+
+	return comparison
+}
+
+func sortTypeRank(value interface{}) int {
+	switch value.(type) {
+	case nil:
+		return 0
+	case bool:
+		return 1
+	case int64, float64, json.Number:
+		return 2
+	case string:
+		return 3
+	}
+
+	return 4
+}
+
+// lookupPath resolves a dotted path such as `author.name` or `tags.0` against a record. The second return
+// value is false if any segment of the path does not exist.
+//
+func lookupPath(record map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = record
+
+	for _, segment := range strings.Split(path, ".") {
+		switch value := current.(type) {
+		case map[string]interface{}:
+			next, ok := value[segment]
+			if !ok {
+				return nil, false
+			}
+
+			current = next
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(value) {
+				return nil, false
+			}
+
+			current = value[index]
+		default:
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+// coerceQueryValue converts a raw query string value to the type of `stored` so that the two may be compared.
+// The second return value is false if `raw` can't be represented as that type.
+//
+func coerceQueryValue(raw string, stored interface{}) (interface{}, bool) {
+	switch stored.(type) {
+	case nil:
+		if raw == "null" {
+			return nil, true
+		}
+	case int64, float64, json.Number:
+		if numberPattern.MatchString(raw) {
+			return json.Number(raw), true
+		}
+	case bool:
+		if boolean, err := strconv.ParseBool(raw); err == nil {
+			return boolean, true
+		}
+	case string:
+		return raw, true
+	}
+
+	return nil, false
+}
+
+// compareValues orders two values of the same type, returning a negative number if a < b, zero if they are
+// equal and a positive number if a > b. The second return value is false if the values can't be ordered.
+//
+func compareValues(a, b interface{}) (int, bool) {
+	switch a := a.(type) {
+	case int64, float64, json.Number:
+		return compareNumbers(a, b)
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	case bool:
+		if b, ok := b.(bool); ok {
+			switch {
+			case a == b:
+				return 0, true
+			case b:
+				return -1, true
+			}
+
+			return 1, true
+		}
+	}
+
+	return 0, false
+}
+
+// compareNumbers orders two numbers of any of the types produced by convertNumber. Integers are compared exactly,
+// anything involving a float64 is compared as float64, and preserved json.Numbers are compared exactly against
+// integers and each other.
+//
+func compareNumbers(a, b interface{}) (int, bool) {
+	aInt, aIsInt := a.(int64)
+	bInt, bIsInt := b.(int64)
+
+	if aIsInt && bIsInt {
+		switch {
+		case aInt < bInt:
+			return -1, true
+		case aInt > bInt:
+			return 1, true
+		}
+
+		return 0, true
+	}
+
+	_, aIsFloat := a.(float64)
+	_, bIsFloat := b.(float64)
+
+	if aIsFloat || bIsFloat {
+		aFloat, aOk := floatValue(a)
+		bFloat, bOk := floatValue(b)
+
+		if !aOk || !bOk {
+			return 0, false
+		}
+
+		switch {
+		case aFloat < bFloat:
+			return -1, true
+		case aFloat > bFloat:
+			return 1, true
+		}
+
+		return 0, true
+	}
+
+	aRat, aOk := ratValue(a)
+	bRat, bOk := ratValue(b)
+
+	if !aOk || !bOk {
+		return 0, false
+	}
+
+	return aRat.Cmp(bRat), true
+}
+
+func floatValue(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case int64:
+		return float64(value), true
+	case float64:
+		return value, true
+	case json.Number:
+		float, err := value.Float64()
+		return float, err == nil
+	}
+
+	return 0, false
+}
+
+func ratValue(value interface{}) (*big.Rat, bool) {
+	switch value := value.(type) {
+	case int64:
+		return new(big.Rat).SetInt64(value), true
+	case json.Number:
+		return new(big.Rat).SetString(value.String())
+	}
+
+	return nil, false
+}
+
+// valuesEqual returns whether two scalar values are equal. Numbers are equal if they have the same value, no
+// matter which type they are stored as.
+//
+func valuesEqual(a, b interface{}) bool {
+	if comparison, ok := compareNumbers(a, b); ok {
+		return comparison == 0
+	}
+
+	return a == b
+}
+
+// stringValue returns the text of a scalar value as it would appear in JSON, without quotes for strings
+//
+func stringValue(value interface{}) string {
+	switch value := value.(type) {
+	case string:
+		return value
+	case nil:
+		return "null"
+	}
+
+	return fmt.Sprint(value)
+}
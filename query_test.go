@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"testing"
+)
+
+func TestLookupPath(t *testing.T) {
+	record := map[string]interface{}{
+		"id": int64(1),
+		"meta": map[string]interface{}{
+			"lang": "en",
+		},
+		"tags": []interface{}{"a", "b"},
+	}
+
+	tests := map[string]interface{}{
+		"id":        int64(1),
+		"meta.lang": "en",
+		"tags.1":    "b",
+	}
+
+	for path, expected := range tests {
+		actual, ok := lookupPath(record, path)
+		if !ok || actual != expected {
+			t.Errorf("Path %s: expected %#v, got %#v", path, expected, actual)
+		}
+	}
+
+	for _, path := range []string{"missing", "meta.missing", "tags.5", "id.nested"} {
+		if _, ok := lookupPath(record, path); ok {
+			t.Errorf("Path %s should not have resolved", path)
+		}
+	}
+}
+
+func TestFilterRecords(t *testing.T) {
+	rows := []interface{}{
+		map[string]interface{}{"id": int64(1), "published": true, "editor": nil, "meta": map[string]interface{}{"lang": "en"}, "views": int64(10), "createdAt": "2015-01-01"},
+		map[string]interface{}{"id": int64(2), "published": false, "editor": "Foo", "meta": map[string]interface{}{"lang": "de"}, "views": int64(150)},
+		map[string]interface{}{"id": int64(3), "published": true, "editor": "Bar", "meta": map[string]interface{}{"lang": "en"}, "views": int64(99), "createdAt": "2015-06-01"},
+	}
+
+	tests := map[string][]int64{
+		"published=true":                        []int64{1, 3},
+		"published=false":                       []int64{2},
+		"editor=null":                           []int64{1},
+		"meta.lang=de":                          []int64{2},
+		"id=1&id=2":                             []int64{1, 2},
+		"id=1&meta.lang=de":                     []int64{},
+		"published=notabool":                    []int64{},
+		"":                                      []int64{1, 2, 3},
+		"views_gt=10":                           []int64{2, 3},
+		"views_gte=10&views_lt=100":             []int64{1, 3},
+		"views_lte=99":                          []int64{1, 3},
+		"views_lt=abc":                          []int64{},
+		"createdAt_gte=2015-02-01":              []int64{3},
+		"id_ne=2":                               []int64{1, 3},
+		"editor_ne=Foo&editor_ne=Bar":           []int64{1},
+		"id_in=1,3":                             []int64{1, 3},
+		"meta.lang_in=de&meta.lang_in=fr":       []int64{2},
+		"editor_like=^B":                        []int64{3},
+		"views_like=^1":                         []int64{1, 2},
+		"createdAt_exists=true":                 []int64{1, 3},
+		"createdAt_exists=false":                []int64{2},
+		"meta.lang_exists=true&published=false": []int64{2},
+	}
+
+	for rawQuery, expectedIds := range tests {
+		query, _ := url.ParseQuery(rawQuery)
+		results, err := filterRecords(rows, query)
+		if err != nil {
+			t.Errorf("Query %q: unexpected error %s", rawQuery, err)
+			continue
+		}
+
+		if len(results) != len(expectedIds) {
+			t.Errorf("Query %q: expected %d results, got %d", rawQuery, len(expectedIds), len(results))
+			continue
+		}
+
+		for i, expectedId := range expectedIds {
+			if id := results[i].(map[string]interface{})["id"]; id != expectedId {
+				t.Errorf("Query %q: expected ID %d at index %d, got %v", rawQuery, expectedId, i, id)
+			}
+		}
+	}
+}
+
+func TestFilterNumbers(t *testing.T) {
+	rows := []interface{}{
+		map[string]interface{}{"id": int64(1), "price": 19.99},
+		map[string]interface{}{"id": int64(2), "price": int64(20)},
+		map[string]interface{}{"id": int64(3), "price": json.Number("12345678901234567890")},
+	}
+
+	tests := map[string][]int64{
+		"price=19.99":                   []int64{1},
+		"price=20.0":                    []int64{2},
+		"price_lt=20":                   []int64{1},
+		"price_gte=19.995":              []int64{2, 3},
+		"price=12345678901234567890":    []int64{3},
+		"price_gt=12345678901234567889": []int64{3},
+		"price=abc":                     []int64{},
+	}
+
+	for rawQuery, expectedIds := range tests {
+		query, _ := url.ParseQuery(rawQuery)
+		results, err := filterRecords(rows, query)
+		if err != nil {
+			t.Errorf("Query %q: unexpected error %s", rawQuery, err)
+			continue
+		}
+
+		if ids := rowIds(results); !idsEqual(ids, expectedIds) {
+			t.Errorf("Query %q: expected %v, got %v", rawQuery, expectedIds, ids)
+		}
+	}
+}
+
+func TestFilterRecordsInvalidOperators(t *testing.T) {
+	for _, rawQuery := range []string{"title_like=(", "title_exists=maybe"} {
+		query, _ := url.ParseQuery(rawQuery)
+
+		if _, err := filterRecords([]interface{}{}, query); err == nil {
+			t.Errorf("Query %q: expected an error", rawQuery)
+		}
+	}
+}
+
+func TestCompareValues(t *testing.T) {
+	tests := []struct {
+		A, B     interface{}
+		Expected int
+		Ok       bool
+	}{
+		{int64(1), int64(2), -1, true},
+		{int64(2), int64(2), 0, true},
+		{"b", "a", 1, true},
+		{false, true, -1, true},
+		{int64(1), "1", 0, false},
+		{nil, nil, 0, false},
+		{19.99, int64(20), -1, true},
+		{int64(20), 19.99, 1, true},
+		{json.Number("12345678901234567891"), json.Number("12345678901234567890"), 1, true},
+		{json.Number("12345678901234567890"), int64(1), 1, true},
+		{19.99, json.Number("19.99"), 0, true},
+	}
+
+	for _, test := range tests {
+		comparison, ok := compareValues(test.A, test.B)
+		if comparison != test.Expected || ok != test.Ok {
+			t.Errorf("compareValues(%#v, %#v): expected (%d, %t), got (%d, %t)", test.A, test.B, test.Expected, test.Ok, comparison, ok)
+		}
+	}
+}
+
+func TestSortRecords(t *testing.T) {
+	newRows := func() []interface{} {
+		return []interface{}{
+			map[string]interface{}{"id": int64(1), "title": "b", "views": int64(5)},
+			map[string]interface{}{"id": int64(2), "title": "a", "views": "many"},
+			map[string]interface{}{"id": int64(3), "title": "b"},
+			map[string]interface{}{"id": int64(4), "title": "a", "views": int64(5)},
+		}
+	}
+
+	tests := map[string][]int64{
+		"":              []int64{1, 2, 3, 4},
+		"title":         []int64{2, 4, 1, 3},
+		"-title":        []int64{1, 3, 2, 4},
+		"title,-id":     []int64{4, 2, 3, 1},
+		"views":         []int64{3, 1, 4, 2},
+		"-views,title":  []int64{2, 4, 1, 3},
+		" title , ,-id": []int64{4, 2, 3, 1},
+	}
+
+	for spec, expectedIds := range tests {
+		rows := newRows()
+		sortRecords(rows, spec, "id")
+
+		for i, expectedId := range expectedIds {
+			if id := rows[i].(map[string]interface{})["id"]; id != expectedId {
+				t.Errorf("Sort %q: expected ID %d at index %d, got %v", spec, expectedId, i, id)
+			}
+		}
+	}
+}
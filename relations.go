@@ -0,0 +1,292 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// singularize returns the singular form of a collection name, e.g. "posts" -> "post", "categories" -> "category"
+//
+func singularize(name string) string {
+	switch {
+	case strings.HasSuffix(name, "ies") && len(name) > 3:
+		return strings.TrimSuffix(name, "ies") + "y"
+	case strings.HasSuffix(name, "sses"),
+		strings.HasSuffix(name, "xes"),
+		strings.HasSuffix(name, "ches"),
+		strings.HasSuffix(name, "shes"):
+		return strings.TrimSuffix(name, "es")
+	case strings.HasSuffix(name, "ss"):
+		return name
+	case strings.HasSuffix(name, "s"):
+		return strings.TrimSuffix(name, "s")
+	}
+
+	return name
+}
+
+// foreignKeyName returns the name of the field used by other records to refer to a record in `itemType`
+//
+func foreignKeyName(itemType string) string {
+	template := config.ForeignKey
+
+	switch template {
+	case "camel":
+		template = "{singular}Id"
+	case "snake":
+		template = "{singular}_id"
+	}
+
+	return strings.Replace(template, "{singular}", singularize(itemType), -1)
+}
+
+// collectionForSingular finds the collection whose singular name is `singular`
+//
+func collectionForSingular(data BackingData, singular string) (string, bool) {
+	for _, itemType := range data.ItemTypes() {
+		if singularize(itemType) == singular {
+			return itemType, true
+		}
+	}
+
+	return "", false
+}
+
+// Relation describes a foreign key from the records of one collection to the records of another
+//
+type Relation struct {
+	// Child is the collection holding the foreign key, e.g. "comments"
+	Child string
+	// Parent is the collection being referred to, e.g. "posts"
+	Parent string
+	// ForeignKey is the field of Child holding the ID of a Parent record, e.g. "postId"
+	ForeignKey string
+	// OnDelete is the policy applied to Child records when their Parent is deleted (one of the OnDelete* constants)
+	OnDelete string
+}
+
+// inferRelations finds every pair of collections where records of one hold a foreign key (as named by
+// foreignKeyName) to the other. Relations are sorted by child and then parent collection.
+//
+func inferRelations(data BackingData) []Relation {
+	relations := []Relation{}
+	itemTypes := data.ItemTypes()
+	sort.Strings(itemTypes)
+
+	for _, child := range itemTypes {
+		rows, _ := data.ItemType(child)
+
+		for _, parent := range itemTypes {
+			foreignKey := foreignKeyName(parent)
+
+			for _, row := range rows {
+				if record, ok := row.(map[string]interface{}); ok {
+					if _, ok := record[foreignKey]; ok {
+						relations = append(relations, Relation{Child: child, Parent: parent, ForeignKey: foreignKey})
+						break
+					}
+				}
+			}
+		}
+	}
+
+	return relations
+}
+
+// relationQuery holds the `_embed` and `_expand` parameters of a request. Both accept comma separated lists and
+// dotted paths for multiple levels:
+//
+//    GET /posts/1?_embed=comments.likes    (comments of the post, each with their likes)
+//    GET /comments?_expand=post.user       (the post of each comment, with the post's user)
+//
+type relationQuery struct {
+	embed  fieldTree
+	expand fieldTree
+}
+
+func parseRelationQuery(query url.Values) relationQuery {
+	return relationQuery{
+		embed:  parseFieldTree(query["_embed"]),
+		expand: parseFieldTree(query["_expand"]),
+	}
+}
+
+// Empty returns whether the query neither embeds nor expands anything
+//
+func (q relationQuery) Empty() bool {
+	return q.embed == nil && q.expand == nil
+}
+
+// Apply returns a copy of `record` (a member of `itemType`) with its related records inlined
+//
+func (q relationQuery) Apply(data BackingData, itemType string, record map[string]interface{}) (map[string]interface{}, error) {
+	if q.Empty() {
+		return record, nil
+	}
+
+	return newRelationResolver(data).resolve(itemType, record, q.embed, q.expand)
+}
+
+// ApplyAll inlines related records for every row of `itemType`
+//
+func (q relationQuery) ApplyAll(data BackingData, itemType string, rows []interface{}) ([]interface{}, error) {
+	if q.Empty() {
+		return rows, nil
+	}
+
+	resolver := newRelationResolver(data)
+	resolved := make([]interface{}, len(rows))
+
+	for i, row := range rows {
+		record, ok := row.(map[string]interface{})
+		if !ok {
+			resolved[i] = row
+			continue
+		}
+
+		record, err := resolver.resolve(itemType, record, q.embed, q.expand)
+		if err != nil {
+			return nil, err
+		}
+
+		resolved[i] = record
+	}
+
+	return resolved, nil
+}
+
+// relationResolver inlines related records, following the relations in use by the server (see buildRelations).
+// Children are grouped by their foreign key the first time a collection is embedded so that embedding into a whole
+// collection doesn't rescan the children for every row.
+//
+type relationResolver struct {
+	data      BackingData
+	relations []Relation
+	children  map[string]map[string][]map[string]interface{}
+}
+
+func newRelationResolver(data BackingData) *relationResolver {
+	return &relationResolver{
+		data:      data,
+		relations: relations,
+		children:  make(map[string]map[string][]map[string]interface{}),
+	}
+}
+
+// foreignKeys returns the fields of `childType` records which refer to `parentType` records: those of the relations
+// between the two, or the one named by foreignKeyName if there aren't any (e.g. because no child has one yet)
+//
+func (resolver *relationResolver) foreignKeys(childType, parentType string) []string {
+	foreignKeys := []string{}
+
+	for _, relation := range resolver.relations {
+		if relation.Child == childType && relation.Parent == parentType {
+			foreignKeys = append(foreignKeys, relation.ForeignKey)
+		}
+	}
+
+	if len(foreignKeys) == 0 {
+		foreignKeys = append(foreignKeys, foreignKeyName(parentType))
+	}
+
+	return foreignKeys
+}
+
+func (resolver *relationResolver) resolve(itemType string, record map[string]interface{}, embed, expand fieldTree) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(record)+len(embed)+len(expand))
+	for key, value := range record {
+		result[key] = value
+	}
+
+	for childType, subtree := range embed {
+		children, err := resolver.childrenOf(childType, itemType)
+		if err != nil {
+			return nil, err
+		}
+
+		embedded := make([]interface{}, 0)
+		parentKey, _ := idKey(record[primaryKey(itemType)])
+
+		for _, child := range children[parentKey] {
+			child, err := resolver.resolve(childType, child, subtree, nil)
+			if err != nil {
+				return nil, err
+			}
+
+			embedded = append(embedded, child)
+		}
+
+		result[childType] = embedded
+	}
+
+	for singular, subtree := range expand {
+		parentType, ok := collectionForSingular(resolver.data, singular)
+		if !ok {
+			return nil, fmt.Errorf("unknown relation %s", singular)
+		}
+
+		for _, foreignKey := range resolver.foreignKeys(itemType, parentType) {
+			parent, err := resolver.data.RecordWithId(parentType, record[foreignKey])
+			if err != nil {
+				continue
+			}
+
+			parent, err = resolver.resolve(parentType, parent, nil, subtree)
+			if err != nil {
+				return nil, err
+			}
+
+			result[singular] = parent
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// childrenOf returns the records of `childType` grouped by the idKey of their foreign key to `parentType`
+//
+func (resolver *relationResolver) childrenOf(childType, parentType string) (map[string][]map[string]interface{}, error) {
+	cacheKey := childType + "." + parentType
+	if children, ok := resolver.children[cacheKey]; ok {
+		return children, nil
+	}
+
+	rows, err := resolver.data.ItemType(childType)
+	if err != nil {
+		return nil, fmt.Errorf("unknown collection %s", childType)
+	}
+
+	foreignKeys := resolver.foreignKeys(childType, parentType)
+	children := make(map[string][]map[string]interface{})
+
+	for _, row := range rows {
+		child, ok := row.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+	foreignKeyLoop:
+		for i, foreignKey := range foreignKeys {
+			parentKey, ok := idKey(child[foreignKey])
+			if !ok {
+				continue
+			}
+
+			// A child referring to the same parent through several relations is only embedded once
+			for _, previous := range foreignKeys[:i] {
+				if previousKey, ok := idKey(child[previous]); ok && previousKey == parentKey {
+					continue foreignKeyLoop
+				}
+			}
+
+			children[parentKey] = append(children[parentKey], child)
+		}
+	}
+
+	resolver.children[cacheKey] = children
+
+	return children, nil
+}
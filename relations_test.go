@@ -0,0 +1,193 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestSingularize(t *testing.T) {
+	tests := map[string]string{
+		"posts":      "post",
+		"categories": "category",
+		"boxes":      "box",
+		"matches":    "match",
+		"addresses":  "address",
+		"class":      "class",
+		"people":     "people",
+	}
+
+	for plural, expected := range tests {
+		if actual := singularize(plural); actual != expected {
+			t.Errorf("singularize(%q): expected %q, got %q", plural, expected, actual)
+		}
+	}
+}
+
+func TestForeignKeyName(t *testing.T) {
+	defer withDefaultConfig()()
+
+	tests := map[string]string{
+		"":                 "postId",
+		"snake":            "post_id",
+		"camel":            "postId",
+		"{singular}Ref":    "postRef",
+		"fk_{singular}_id": "fk_post_id",
+	}
+
+	for template, expected := range tests {
+		config = defaultConfig()
+		if template != "" {
+			config.ForeignKey = template
+		}
+
+		if actual := foreignKeyName("posts"); actual != expected {
+			t.Errorf("Template %q: expected %q, got %q", template, expected, actual)
+		}
+	}
+}
+
+func TestRelationQuery(t *testing.T) {
+	data := BackingData{
+		"users": []interface{}{
+			map[string]interface{}{"id": int64(1), "name": "Foo"},
+		},
+		"posts": []interface{}{
+			map[string]interface{}{"id": int64(1), "userId": int64(1)},
+			map[string]interface{}{"id": int64(2), "userId": int64(9)},
+		},
+		"comments": []interface{}{
+			map[string]interface{}{"id": int64(1), "postId": int64(1)},
+			map[string]interface{}{"id": int64(2), "postId": int64(1)},
+			map[string]interface{}{"id": int64(3), "postId": nil},
+		},
+		"likes": []interface{}{
+			map[string]interface{}{"id": int64(1), "commentId": int64(2)},
+		},
+	}
+
+	posts, _ := data.ItemType("posts")
+	comments, _ := data.ItemType("comments")
+
+	query, _ := url.ParseQuery("_embed=comments.likes&_expand=user")
+	actual, err := parseRelationQuery(query).ApplyAll(data, "posts", posts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []interface{}{
+		map[string]interface{}{
+			"id":     int64(1),
+			"userId": int64(1),
+			"user":   map[string]interface{}{"id": int64(1), "name": "Foo"},
+			"comments": []interface{}{
+				map[string]interface{}{"id": int64(1), "postId": int64(1), "likes": []interface{}{}},
+				map[string]interface{}{"id": int64(2), "postId": int64(1), "likes": []interface{}{
+					map[string]interface{}{"id": int64(1), "commentId": int64(2)},
+				}},
+			},
+		},
+		map[string]interface{}{
+			"id":       int64(2),
+			"userId":   int64(9),
+			"comments": []interface{}{},
+		},
+	}
+
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, actual)
+	}
+
+	query, _ = url.ParseQuery("_expand=post.user")
+	expanded, err := parseRelationQuery(query).Apply(data, "comments", comments[0].(map[string]interface{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name, _ := lookupPath(expanded, "post.user.name"); name != "Foo" {
+		t.Errorf("Expected post.user.name to be expanded, got %#v", expanded)
+	}
+
+	if _, ok := comments[0].(map[string]interface{})["post"]; ok {
+		t.Error("The stored record should not have been modified")
+	}
+
+	for _, rawQuery := range []string{"_embed=missing", "_expand=missing"} {
+		query, _ = url.ParseQuery(rawQuery)
+		if _, err := parseRelationQuery(query).ApplyAll(data, "posts", posts); err == nil {
+			t.Errorf("Query %q: expected an error", rawQuery)
+		}
+	}
+}
+
+func TestRelationQueryDeclaredRelations(t *testing.T) {
+	relationsBeforeModification := relations
+	defer func() {
+		relations = relationsBeforeModification
+	}()
+
+	data := BackingData{
+		"users": []interface{}{
+			map[string]interface{}{"id": "u1", "name": "Foo"},
+		},
+		"posts": []interface{}{
+			map[string]interface{}{"id": int64(1), "writer": "u1"},
+			map[string]interface{}{"id": int64(2), "writer": "u2"},
+		},
+	}
+
+	// posts.writer->users doesn't follow the naming convention, so it has to be declared
+	relations = []Relation{Relation{Child: "posts", Parent: "users", ForeignKey: "writer"}}
+
+	users, _ := data.ItemType("users")
+	posts, _ := data.ItemType("posts")
+
+	query, _ := url.ParseQuery("_embed=posts")
+	embedded, err := parseRelationQuery(query).Apply(data, "users", users[0].(map[string]interface{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedPosts := []interface{}{map[string]interface{}{"id": int64(1), "writer": "u1"}}
+	if !reflect.DeepEqual(embedded["posts"], expectedPosts) {
+		t.Errorf("Expected the posts of the writer to be embedded, got %#v", embedded)
+	}
+
+	query, _ = url.ParseQuery("_expand=user")
+	expanded, err := parseRelationQuery(query).ApplyAll(data, "posts", posts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name, _ := lookupPath(expanded[0].(map[string]interface{}), "user.name"); name != "Foo" {
+		t.Errorf("Expected the writer to be expanded, got %#v", expanded[0])
+	}
+
+	if _, ok := expanded[1].(map[string]interface{})["user"]; ok {
+		t.Errorf("Expected nothing to be expanded for a missing writer, got %#v", expanded[1])
+	}
+}
+
+func TestInferRelations(t *testing.T) {
+	data := BackingData{
+		"posts": []interface{}{
+			map[string]interface{}{"id": int64(1)},
+		},
+		"comments": []interface{}{
+			map[string]interface{}{"id": int64(1), "body": "Foo"},
+			map[string]interface{}{"id": int64(2), "postId": int64(1)},
+		},
+		"categories": []interface{}{
+			map[string]interface{}{"id": int64(1), "categoryId": nil},
+		},
+	}
+
+	expected := []Relation{
+		Relation{Child: "categories", Parent: "categories", ForeignKey: "categoryId"},
+		Relation{Child: "comments", Parent: "posts", ForeignKey: "postId"},
+	}
+
+	if actual := inferRelations(data); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected %#v, got %#v", expected, actual)
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// fileChecksum is the checksum of the JSON file as it was last loaded or written by flushData, which tells the
+	// watcher whether a change to the file was made by someone else. It is protected by flushMutex.
+	fileChecksum string
+
+	// ConfigFilePath is the configuration file which was loaded, if any
+	ConfigFilePath string
+
+	// rebuildConfig builds the configuration from scratch (from the configuration file, the environment and the
+	// command line flags), so that it can be reloaded when the configuration file changes. It is set by
+	// serveCommand.
+	rebuildConfig func() error
+)
+
+// reloadableHandler serves requests with a handler which is rebuilt when the data or configuration are reloaded, so
+// that routes can be added and removed. Requests hold a read lock for their whole duration: a reload waits for the
+// requests in flight to complete and new requests wait for the reload, but connections are never dropped.
+//
+type reloadableHandler struct {
+	mutex   sync.RWMutex
+	handler http.Handler
+	build   func() http.Handler
+
+	// stale is set (to 1) by routesChanged when a request added or removed a collection. It is only accessed
+	// atomically.
+	stale int32
+}
+
+// reloadableHandlerKey is the context key of the reloadableHandler serving a request
+//
+type reloadableHandlerKey struct{}
+
+func newReloadableHandler(build func() http.Handler) *reloadableHandler {
+	return &reloadableHandler{handler: build(), build: build}
+}
+
+func (h *reloadableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	func() {
+		h.mutex.RLock()
+		defer h.mutex.RUnlock()
+
+		h.handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), reloadableHandlerKey{}, h)))
+	}()
+
+	// The response isn't sent until this returns, so the client can use the new routes as soon as it has it
+	if atomic.SwapInt32(&h.stale, 0) == 1 {
+		h.reload(func() (bool, error) {
+			return true, nil
+		})
+	}
+}
+
+// routesChanged rebuilds the routes of the server handling `r` once the request completes. Handlers which add or
+// remove collections must call it, since routes can't be changed while requests are being handled.
+//
+func routesChanged(r *http.Request) {
+	if h, ok := r.Context().Value(reloadableHandlerKey{}).(*reloadableHandler); ok {
+		atomic.StoreInt32(&h.stale, 1)
+	}
+}
+
+// reload runs `change` while no request is being handled and rebuilds the handler if it returns true
+//
+func (h *reloadableHandler) reload(change func() (bool, error)) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	changed, err := change()
+	if changed {
+		h.handler = h.build()
+	}
+
+	return err
+}
+
+// fileState identifies a version of a file cheaply, so that the watcher only reads files which have changed
+//
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+func statFile(fname string) fileState {
+	info, err := os.Stat(fname)
+	if err != nil {
+		return fileState{}
+	}
+
+	return fileState{info.ModTime(), info.Size()}
+}
+
+// watchFiles checks the JSON file and the configuration file (unless it is "") for changes every `interval` until
+// `stop` is closed, and reloads them through `handler` when they change
+//
+func watchFiles(handler *reloadableHandler, dataFile, configFile string, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	dataState, configState := statFile(dataFile), statFile(configFile)
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if configFile != "" {
+			if state := statFile(configFile); state != configState {
+				configState = state
+
+				if err := handler.reload(reloadConfig); err != nil {
+					logger.Errorf("Not reloading %s: %s\n", configFile, err)
+				} else {
+					logger.Infof("Reloaded %s\n", configFile)
+				}
+			}
+		}
+
+		if state := statFile(dataFile); state != dataState {
+			dataState = state
+
+			if !fileChangedExternally(dataFile) {
+				continue
+			}
+
+			err := handler.reload(func() (bool, error) {
+				return reloadData(dataFile)
+			})
+
+			if err != nil {
+				logger.Errorf("Not reloading %s: %s\n", dataFile, err)
+			} else {
+				logger.Infof("Reloaded %s\n", dataFile)
+			}
+		}
+	}
+}
+
+// fileChangedExternally returns whether the contents of the JSON file differ from what was last loaded or written
+// by flushData, so that the file isn't reloaded after every flush
+//
+func fileChangedExternally(fname string) bool {
+	jsonData, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return false
+	}
+
+	flushMutex.Lock()
+	defer flushMutex.Unlock()
+
+	return dataChecksum(jsonData) != fileChecksum
+}
+
+// reloadData replaces the data with the contents of the JSON file, unless it hasn't changed since it was last loaded
+// or written. Changes which hadn't been flushed yet are discarded, since the file was edited after they were made.
+// It must be called through reloadableHandler.reload, so that no requests are being handled.
+//
+func reloadData(fname string) (bool, error) {
+	flushMutex.Lock()
+	defer flushMutex.Unlock()
+
+	data, jsonData, err := loadJsonFile(fname)
+	if err != nil {
+		return false, err
+	}
+
+	checksum := dataChecksum(jsonData)
+	if checksum == fileChecksum {
+		return false, nil
+	}
+
+	dataMutex.Lock()
+	defer dataMutex.Unlock()
+
+	if atomic.SwapInt32(&dirty, 0) == 1 {
+		logger.Warnf("Discarding changes which hadn't been written to %s before it was edited\n", fname)
+	}
+
+	serverData = data
+	initialData = data.Copy()
+	fileChecksum = checksum
+
+	logUnservableItemTypes(serverData)
+
+	return true, wal.reset(checksum)
+}
+
+// reloadConfig rebuilds the configuration after the configuration file changed. Settings which can't change while
+// the server is running keep their value, with a warning. The collections are rebuilt so that changes to their
+// primary keys and indexes take effect. It must be called through reloadableHandler.reload.
+//
+func reloadConfig() (bool, error) {
+	if rebuildConfig == nil {
+		return false, nil
+	}
+
+	previous := config
+
+	if err := rebuildConfig(); err != nil {
+		config = previous
+		return false, err
+	}
+
+	fixed := map[string]bool{
+		"data":          config.DataFile != previous.DataFile,
+		"host":          config.Host != previous.Host,
+		"port":          config.Port != previous.Port,
+		"flush":         config.FlushPolicy != previous.FlushPolicy,
+		"flushInterval": config.FlushInterval != previous.FlushInterval,
+		"watch":         config.Watch != previous.Watch || config.WatchInterval != previous.WatchInterval,
+	}
+
+	for setting, changed := range fixed {
+		if changed {
+			logger.Warnf("Changing %s requires a restart\n", setting)
+		}
+	}
+
+	config.DataFile, config.Host, config.Port = previous.DataFile, previous.Host, previous.Port
+	config.FlushPolicy, config.FlushInterval = previous.FlushPolicy, previous.FlushInterval
+	config.Watch, config.WatchInterval = previous.Watch, previous.WatchInterval
+
+	dataMutex.Lock()
+	defer dataMutex.Unlock()
+
+	for itemType, value := range serverData {
+		if collection, ok := value.(*Collection); ok {
+			serverData[itemType] = newCollection(itemType, collection.Rows)
+		}
+	}
+
+	return true, nil
+}
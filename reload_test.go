@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReloadData(t *testing.T) {
+	defer disableWal()()
+
+	dir := walTestDir(t)
+	defer os.RemoveAll(dir)
+
+	previousData, previousChecksum := serverData, fileChecksum
+	defer func() {
+		serverData, fileChecksum = previousData, previousChecksum
+	}()
+
+	fname := filepath.Join(dir, "db.json")
+	ioutil.WriteFile(fname, []byte(`{"posts": [{"id": 1}]}`), 0644)
+
+	serverData, _, _ = loadJsonFile(fname)
+	fileChecksum = dataChecksum([]byte(`{"posts": [{"id": 1}]}`))
+
+	if fileChangedExternally(fname) {
+		t.Error("Expected the file to be unchanged")
+	}
+
+	if reloaded, err := reloadData(fname); reloaded || err != nil {
+		t.Errorf("Expected an unchanged file not to be reloaded, got %t (%v)", reloaded, err)
+	}
+
+	ioutil.WriteFile(fname, []byte(`{"tags": [{"id": 1}]}`), 0644)
+	markDirty()
+
+	if !fileChangedExternally(fname) {
+		t.Error("Expected the edit to be noticed")
+	}
+
+	if reloaded, err := reloadData(fname); !reloaded || err != nil {
+		t.Fatalf("Expected the file to be reloaded, got %t (%v)", reloaded, err)
+	}
+
+	if _, ok := serverData["posts"]; ok {
+		t.Error("Expected the removed collection to be gone")
+	}
+
+	if _, err := serverData.RecordWithId("tags", int64(1)); err != nil {
+		t.Errorf("Expected the added collection to be loaded: %s", err)
+	}
+
+	if atomic.LoadInt32(&dirty) != 0 {
+		t.Error("Expected unflushed changes to be discarded")
+	}
+
+	ioutil.WriteFile(fname, []byte(`{"tags": [`), 0644)
+
+	if reloaded, err := reloadData(fname); reloaded || err == nil {
+		t.Errorf("Expected invalid JSON not to be loaded, got %t (%v)", reloaded, err)
+	}
+}
+
+func TestReloadableHandler(t *testing.T) {
+	builds := 0
+
+	handler := newReloadableHandler(func() http.Handler {
+		builds++
+		body := fmt.Sprint(builds)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(body))
+		})
+	})
+
+	handler.reload(func() (bool, error) {
+		return false, nil
+	})
+
+	handler.reload(func() (bool, error) {
+		return true, nil
+	})
+
+	if builds != 2 {
+		t.Errorf("Expected the handler to be rebuilt only when something changed, got %d builds", builds)
+	}
+}
+
+func TestWatchFiles(t *testing.T) {
+	defer disableWal()()
+
+	dir := walTestDir(t)
+	defer os.RemoveAll(dir)
+
+	previousData, previousChecksum, previousPath, previousConfig := serverData, fileChecksum, JsonFilePath, config
+	defer func() {
+		serverData, fileChecksum, JsonFilePath, config = previousData, previousChecksum, previousPath, previousConfig
+	}()
+
+	fname := filepath.Join(dir, "db.json")
+	jsonData := []byte(`{"posts": [{"id": 1}]}`)
+	ioutil.WriteFile(fname, jsonData, 0644)
+
+	serverData, _, _ = loadJsonFile(fname)
+	fileChecksum, JsonFilePath = dataChecksum(jsonData), fname
+	config.Watch, config.WatchInterval, config.FlushPolicy = true, 10*time.Millisecond, FlushNever
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := serveInBackground(listener)
+	defer server.Shutdown()
+
+	status := func(path string) int {
+		resp, err := http.Get(fmt.Sprintf("http://%s%s", listener.Addr(), path))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	if status("/tags") != http.StatusNotFound {
+		t.Fatal("Expected /tags not to exist yet")
+	}
+
+	ioutil.WriteFile(fname, []byte(`{"tags": [{"id": 1, "name": "Foo"}]}`), 0644)
+
+	for deadline := time.Now().Add(5 * time.Second); status("/tags") != http.StatusOK; {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the route of the added collection to be served")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if status("/posts") != http.StatusNotFound {
+		t.Error("Expected the route of the removed collection to be gone")
+	}
+
+	resp, err := http.Post(fmt.Sprintf("http://%s/tags", listener.Addr()), "application/json", strings.NewReader(`{"name": "Bar"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	// Our own writes aren't reloaded, which would discard the changes made since
+	if err := flushData(fname, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if fileChangedExternally(fname) {
+		t.Error("Expected the flushed file not to be treated as an edit")
+	}
+
+	if status("/tags/2") != http.StatusOK {
+		t.Error("Expected the created record to still exist")
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	previousData, previousConfig, previousRebuild := serverData, config, rebuildConfig
+	defer func() {
+		serverData, config, rebuildConfig = previousData, previousConfig, previousRebuild
+	}()
+
+	config = defaultConfig()
+	serverData = BackingData{"posts": newCollection("posts", []interface{}{map[string]interface{}{"slug": "foo"}})}
+
+	rebuildConfig = func() error {
+		config = defaultConfig()
+		config.Port = "4000"
+		config.Collections["posts"] = CollectionConfig{PrimaryKey: "slug"}
+
+		return nil
+	}
+
+	if reloaded, err := reloadConfig(); !reloaded || err != nil {
+		t.Fatalf("Expected the configuration to be reloaded, got %t (%v)", reloaded, err)
+	}
+
+	if config.Port != "3000" {
+		t.Errorf("Expected the port to require a restart, got %s", config.Port)
+	}
+
+	if _, err := serverData.RecordWithId("posts", "foo"); err != nil {
+		t.Errorf("Expected the collection to be indexed by its new primary key: %s", err)
+	}
+
+	rebuildConfig = func() error {
+		config.Port = "5000"
+		return fmt.Errorf("invalid")
+	}
+
+	if _, err := reloadConfig(); err == nil || config.Port != "3000" {
+		t.Errorf("Expected an invalid configuration to be ignored, got port %s (%v)", config.Port, err)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/codegangsta/negroni"
+)
+
+// RouteRewrite maps requests for paths matching From to To before they are routed. `*` matches anything and
+// `:name` matches a single path segment; they are referred to in To as `$1`, `$2`, ... (in order) and `:name`:
+//
+//    "/api/*": "/$1"
+//    "/blog/:resource/:id/show": "/:resource/:id"
+//    "/articles/:category": "/posts?category=:category"
+//
+type RouteRewrite struct {
+	From string
+	To   string
+
+	pattern *regexp.Regexp
+	names   []string
+}
+
+var routeParamPattern = regexp.MustCompile(`^:[A-Za-z0-9_]+`)
+
+func newRouteRewrite(from, to string) (RouteRewrite, error) {
+	rewrite := RouteRewrite{From: from, To: to}
+	expression := "^"
+
+	for rest := from; rest != ""; {
+		if param := routeParamPattern.FindString(rest); param != "" {
+			expression += "([^/]+)"
+			rewrite.names = append(rewrite.names, param)
+			rest = rest[len(param):]
+			continue
+		}
+
+		if rest[0] == '*' {
+			expression += "(.*)"
+			rewrite.names = append(rewrite.names, "")
+		} else {
+			expression += regexp.QuoteMeta(rest[:1])
+		}
+
+		rest = rest[1:]
+	}
+
+	pattern, err := regexp.Compile(expression + "$")
+	if err != nil {
+		return RouteRewrite{}, fmt.Errorf("invalid route %q: %s", from, err)
+	}
+
+	rewrite.pattern = pattern
+
+	return rewrite, nil
+}
+
+// Rewrite changes the path (and possibly the query) of `u` if its path matches the rewrite, returning whether it
+// did. Query parameters in To are added to the ones already present.
+//
+func (rewrite RouteRewrite) Rewrite(u *url.URL) bool {
+	match := rewrite.pattern.FindStringSubmatch(u.Path)
+	if match == nil {
+		return false
+	}
+
+	replacements := []string{}
+	for i := len(match) - 1; i > 0; i-- {
+		replacements = append(replacements, "$"+strconv.Itoa(i), match[i])
+	}
+
+	// Longer names first, so that `:id` doesn't replace the start of `:identifier`
+	named := make([]int, 0, len(rewrite.names))
+	for i, name := range rewrite.names {
+		if name != "" {
+			named = append(named, i)
+		}
+	}
+
+	sort.Slice(named, func(a, b int) bool {
+		return len(rewrite.names[named[a]]) > len(rewrite.names[named[b]])
+	})
+
+	for _, i := range named {
+		replacements = append(replacements, rewrite.names[i], match[i+1])
+	}
+
+	target, err := url.Parse(strings.NewReplacer(replacements...).Replace(rewrite.To))
+	if err != nil {
+		return false
+	}
+
+	query := u.Query()
+	for key, values := range target.Query() {
+		query[key] = append(query[key], values...)
+	}
+
+	u.Path = target.Path
+	u.RawPath = ""
+	u.RawQuery = query.Encode()
+
+	return true
+}
+
+// parseRoutes reads a JSON object of route rewrites, keeping them in the order they were written since the first
+// matching rewrite is used
+//
+func parseRoutes(r io.Reader) ([]RouteRewrite, error) {
+	decoder := json.NewDecoder(r)
+
+	if token, err := decoder.Token(); err != nil || token != json.Delim('{') {
+		return nil, fmt.Errorf("routes must be a JSON object")
+	}
+
+	rewrites := []RouteRewrite{}
+
+	for decoder.More() {
+		from, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		var to string
+		if err := decoder.Decode(&to); err != nil {
+			return nil, fmt.Errorf("invalid route %q: %s", from, err)
+		}
+
+		rewrite, err := newRouteRewrite(from.(string), to)
+		if err != nil {
+			return nil, err
+		}
+
+		rewrites = append(rewrites, rewrite)
+	}
+
+	return rewrites, nil
+}
+
+func loadRoutesFile(fname string) ([]RouteRewrite, error) {
+	file, err := os.Open(fname)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	return parseRoutes(file)
+}
+
+// rewriteMiddleware applies the first of `rewrites` matching the request path before the request is routed
+//
+func rewriteMiddleware(rewrites []RouteRewrite) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		for _, rewrite := range rewrites {
+			if rewrite.Rewrite(r.URL) {
+				break
+			}
+		}
+
+		next(w, r)
+	}
+}
+
+// prefixMiddleware serves every route under `prefix` (e.g. "/api"), removing it from the path before the request is
+// routed. Requests outside of it are not found.
+//
+func prefixMiddleware(prefix string) negroni.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		path := strings.TrimPrefix(r.URL.Path, prefix)
+		if path == r.URL.Path || (path != "" && path[0] != '/') {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		if path == "" {
+			path = "/"
+		}
+
+		r.URL.Path = path
+		r.URL.RawPath = ""
+
+		next(w, r)
+	}
+}
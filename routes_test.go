@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRouteRewrite(t *testing.T) {
+	rewrites, err := parseRoutes(strings.NewReader(`{
+		"/api/v1/*": "/$1",
+		"/blog/:resource/:id/show": "/:resource/:id",
+		"/articles/:category": "/posts?category=:category",
+		"/api/*": "/unused/$1"
+	}`))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/api/v1/posts/1", "/posts/1"},
+		{"/blog/posts/1/show", "/posts/1"},
+		{"/articles/news?_limit=5", "/posts?_limit=5&category=news"},
+		{"/api/posts", "/unused/posts"},
+		{"/posts", "/posts"},
+		{"/blog/posts/1", "/blog/posts/1"},
+	}
+
+	for _, test := range tests {
+		u, _ := url.Parse(test.path)
+
+		// The first matching rewrite wins
+		for _, rewrite := range rewrites {
+			if rewrite.Rewrite(u) {
+				break
+			}
+		}
+
+		if u.String() != test.expected {
+			t.Errorf("Expected %s to be rewritten to %s, got %s", test.path, test.expected, u)
+		}
+	}
+}
+
+func TestParseRoutesInvalid(t *testing.T) {
+	for _, routes := range []string{`[]`, `{"/a": 1}`, `{"/a": "/b"`} {
+		if _, err := parseRoutes(strings.NewReader(routes)); err == nil {
+			t.Errorf("Expected an error for %s", routes)
+		}
+	}
+}
+
+func TestPrefixMiddleware(t *testing.T) {
+	tests := map[string]string{
+		"/api/posts/1": "/posts/1",
+		"/api":         "/",
+		"/api/":        "/",
+		"/apis/posts":  "",
+		"/posts":       "",
+	}
+
+	for path, expected := range tests {
+		routed := ""
+		w := httptest.NewRecorder()
+
+		prefixMiddleware("/api")(w, httptest.NewRequest("GET", path, nil), func(w http.ResponseWriter, r *http.Request) {
+			routed = r.URL.Path
+		})
+
+		if routed != expected {
+			t.Errorf("Expected %s to be routed as %q, got %q", path, expected, routed)
+		}
+
+		if expected == "" && w.Code != http.StatusNotFound {
+			t.Errorf("Expected %s to be not found, got %d", path, w.Code)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema describes the records a collection accepts, using a subset of JSON Schema:
+//
+//    {
+//        "type": "object",
+//        "required": ["title"],
+//        "properties": {
+//            "title": { "type": "string", "minLength": 1 },
+//            "views": { "type": "integer", "minimum": 0 },
+//            "status": { "enum": ["draft", "published"] },
+//            "tags": { "type": "array", "items": { "type": "string" } }
+//        }
+//    }
+//
+type Schema struct {
+	// Type is one of "object", "array", "string", "number", "integer", "boolean" or "null". Anything is accepted
+	// if it is empty.
+	Type string `json:"type"`
+
+	// Required lists the fields an object must have
+	Required []string `json:"required"`
+
+	// Properties holds the schemas of the fields of an object. Fields which aren't listed are allowed unless
+	// AdditionalProperties is false.
+	Properties           map[string]*Schema `json:"properties"`
+	AdditionalProperties *bool              `json:"additionalProperties"`
+
+	// Items is the schema of every element of an array
+	Items *Schema `json:"items"`
+
+	// Enum lists the only values which are accepted
+	Enum []interface{} `json:"enum"`
+
+	// Minimum and Maximum bound numbers, MinLength and MaxLength the number of characters of strings and Pattern
+	// is a regular expression strings must match
+	Minimum   *float64 `json:"minimum"`
+	Maximum   *float64 `json:"maximum"`
+	MinLength *int     `json:"minLength"`
+	MaxLength *int     `json:"maxLength"`
+	Pattern   string   `json:"pattern"`
+
+	pattern *regexp.Regexp
+}
+
+// SchemaError lists the ways a record doesn't match the schema of its collection
+//
+type SchemaError struct {
+	Problems []string
+}
+
+func (err *SchemaError) Error() string {
+	return "Record does not match the schema: " + strings.Join(err.Problems, "; ")
+}
+
+// compile checks the schema and its subschemas, compiling their patterns
+//
+func (s *Schema) compile() error {
+	switch s.Type {
+	case "", "object", "array", "string", "number", "integer", "boolean", "null":
+	default:
+		return fmt.Errorf("unknown schema type %q", s.Type)
+	}
+
+	if s.Pattern != "" {
+		pattern, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid schema pattern %q: %s", s.Pattern, err)
+		}
+
+		s.pattern = pattern
+	}
+
+	for _, property := range s.Properties {
+		if property == nil {
+			continue
+		}
+
+		if err := property.compile(); err != nil {
+			return err
+		}
+	}
+
+	if s.Items != nil {
+		return s.Items.compile()
+	}
+
+	return nil
+}
+
+// Validate returns a description of every way `value` doesn't match the schema, which is empty if it does. Problems
+// start with the (dotted) path of the value they concern, e.g. "tags.1".
+//
+func (s *Schema) Validate(value interface{}) []string {
+	problems := []string{}
+	s.validate("", value, &problems)
+
+	return problems
+}
+
+func (s *Schema) validate(path string, value interface{}, problems *[]string) {
+	fail := func(format string, args ...interface{}) {
+		location := path
+		if location == "" {
+			location = "record"
+		}
+
+		*problems = append(*problems, location+" "+fmt.Sprintf(format, args...))
+	}
+
+	if s.Type != "" && !schemaTypeMatches(s.Type, value) {
+		fail("must be of type %s", s.Type)
+		return
+	}
+
+	if len(s.Enum) > 0 && !schemaEnumContains(s.Enum, value) {
+		fail("must be one of the enumerated values")
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for _, field := range s.Required {
+			if _, ok := value[field]; !ok {
+				*problems = append(*problems, joinPath(path, field)+" is required")
+			}
+		}
+
+		fields := make([]string, 0, len(value))
+		for field := range value {
+			fields = append(fields, field)
+		}
+
+		sort.Strings(fields)
+
+		for _, field := range fields {
+			if property, ok := s.Properties[field]; ok && property != nil {
+				property.validate(joinPath(path, field), value[field], problems)
+			} else if !ok && s.AdditionalProperties != nil && !*s.AdditionalProperties {
+				*problems = append(*problems, joinPath(path, field)+" is not allowed")
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range value {
+				s.Items.validate(joinPath(path, fmt.Sprint(i)), item, problems)
+			}
+		}
+	case string:
+		length := utf8.RuneCountInString(value)
+
+		if s.MinLength != nil && length < *s.MinLength {
+			fail("must be at least %d characters long", *s.MinLength)
+		}
+
+		if s.MaxLength != nil && length > *s.MaxLength {
+			fail("must be at most %d characters long", *s.MaxLength)
+		}
+
+		if s.pattern != nil && !s.pattern.MatchString(value) {
+			fail("must match %s", s.Pattern)
+		}
+	default:
+		number, ok := numberValue(value)
+		if !ok {
+			break
+		}
+
+		if s.Minimum != nil && number < *s.Minimum {
+			fail("must be at least %v", *s.Minimum)
+		}
+
+		if s.Maximum != nil && number > *s.Maximum {
+			fail("must be at most %v", *s.Maximum)
+		}
+	}
+}
+
+func joinPath(path, field string) string {
+	if path == "" {
+		return field
+	}
+
+	return path + "." + field
+}
+
+// numberValue returns any of the number types which decodeJson produces as a float64
+//
+func numberValue(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case int64:
+		return float64(value), true
+	case float64:
+		return value, true
+	case json.Number:
+		number, err := value.Float64()
+		return number, err == nil
+	}
+
+	return 0, false
+}
+
+func schemaTypeMatches(schemaType string, value interface{}) bool {
+	switch schemaType {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := numberValue(value)
+		return ok
+	case "integer":
+		number, ok := numberValue(value)
+		return ok && number == math.Trunc(number)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	}
+
+	return true
+}
+
+// schemaEnumContains compares values by their JSON encoding, so that e.g. the int64 1 of a record equals the 1
+// decoded from the configuration
+//
+func schemaEnumContains(enum []interface{}, value interface{}) bool {
+	for _, allowed := range enum {
+		if jsonEqual(value, allowed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateSchema checks `record` against the schema configured for `itemType`, if there is one
+//
+func validateSchema(itemType string, record map[string]interface{}) error {
+	schema := config.Collections[itemType].Schema
+	if schema == nil {
+		return nil
+	}
+
+	if problems := schema.Validate(record); len(problems) > 0 {
+		return &SchemaError{Problems: problems}
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	schema := &Schema{}
+	err := json.Unmarshal([]byte(`{
+		"type": "object",
+		"required": ["title", "views"],
+		"additionalProperties": false,
+		"properties": {
+			"id": {},
+			"title": {"type": "string", "minLength": 1, "maxLength": 10, "pattern": "^[A-Z]"},
+			"views": {"type": "integer", "minimum": 0},
+			"status": {"enum": ["draft", "published"]},
+			"tags": {"type": "array", "items": {"type": "string"}}
+		}
+	}`), schema)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := schema.compile(); err != nil {
+		t.Fatal(err)
+	}
+
+	valid := map[string]interface{}{
+		"id":     int64(1),
+		"title":  "Foo",
+		"views":  int64(10),
+		"status": "draft",
+		"tags":   []interface{}{"a", "b"},
+	}
+
+	if problems := schema.Validate(valid); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %q", problems)
+	}
+
+	invalid := map[string]interface{}{
+		"title":  "lowercase and too long",
+		"views":  1.5,
+		"status": "deleted",
+		"tags":   []interface{}{"a", int64(2)},
+		"extra":  true,
+	}
+
+	expected := []string{
+		"extra is not allowed",
+		"status must be one of the enumerated values",
+		"tags.1 must be of type string",
+		"title must be at most 10 characters long",
+		"title must match ^[A-Z]",
+		"views must be of type integer",
+	}
+
+	if problems := schema.Validate(invalid); !reflect.DeepEqual(problems, expected) {
+		t.Errorf("Expected %q, got %q", expected, problems)
+	}
+
+	if problems := schema.Validate(map[string]interface{}{"title": "Foo"}); !reflect.DeepEqual(problems, []string{"views is required"}) {
+		t.Errorf("Expected the missing field to be reported, got %q", problems)
+	}
+
+	if problems := schema.Validate("Foo"); !reflect.DeepEqual(problems, []string{"record must be of type object"}) {
+		t.Errorf("Expected the type to be checked, got %q", problems)
+	}
+}
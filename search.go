@@ -0,0 +1,109 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+)
+
+// searchRecords returns the rows which contain every word of the `q` parameter in one of their string values.
+// Matching is case-insensitive and descends into nested objects and arrays. `q_fields` restricts the search to
+// a comma separated list of (possibly dotted) fields.
+//
+// If `q_score=true` is given, each result is a copy of the record with a `_score` field counting how many times
+// the search words occur in it.
+//
+func searchRecords(rows []interface{}, query url.Values) []interface{} {
+	words := strings.Fields(strings.ToLower(query.Get("q")))
+	if len(words) == 0 {
+		return rows
+	}
+
+	fields := []string{}
+	for _, field := range strings.Split(query.Get("q_fields"), ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+
+	includeScore := query.Get("q_score") == "true"
+	matches := make([]interface{}, 0, len(rows))
+
+	for _, row := range rows {
+		record, ok := row.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		score := searchScore(record, fields, words)
+		if score == 0 {
+			continue
+		}
+
+		if includeScore {
+			scored := make(map[string]interface{}, len(record)+1)
+			for key, value := range record {
+				scored[key] = value
+			}
+
+			scored["_score"] = int64(score)
+			row = scored
+		}
+
+		matches = append(matches, row)
+	}
+
+	return matches
+}
+
+// searchScore returns the number of occurrences of `words` in the string values of the record (or only the
+// given fields, if any). If any word doesn't occur at all the score is 0.
+//
+func searchScore(record map[string]interface{}, fields []string, words []string) int {
+	counts := make([]int, len(words))
+
+	countWords := func(text string) {
+		text = strings.ToLower(text)
+
+		for i, word := range words {
+			counts[i] += strings.Count(text, word)
+		}
+	}
+
+	if len(fields) == 0 {
+		walkStrings(record, countWords)
+	} else {
+		for _, field := range fields {
+			if value, ok := lookupPath(record, field); ok {
+				walkStrings(value, countWords)
+			}
+		}
+	}
+
+	score := 0
+	for _, count := range counts {
+		if count == 0 {
+			return 0
+		}
+
+		score += count
+	}
+
+	return score
+}
+
+// walkStrings calls `fn` with every string contained in `value`, recursing into maps and arrays
+//
+func walkStrings(value interface{}, fn func(string)) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for _, child := range value {
+			walkStrings(child, fn)
+		}
+	case []interface{}:
+		for _, child := range value {
+			walkStrings(child, fn)
+		}
+	case string:
+		fn(value)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestSearchRecords(t *testing.T) {
+	rows := []interface{}{
+		map[string]interface{}{"id": int64(1), "title": "Hello World", "body": "hello again"},
+		map[string]interface{}{"id": int64(2), "title": "Other", "meta": map[string]interface{}{"tags": []interface{}{"WORLD", "news"}}},
+		map[string]interface{}{"id": int64(3), "title": "Nothing here", "views": int64(7)},
+	}
+
+	tests := map[string][]int64{
+		"q=hello":                    []int64{1},
+		"q=WORLD":                    []int64{1, 2},
+		"q=world+news":               []int64{2},
+		"q=world&q_fields=title":     []int64{1},
+		"q=world&q_fields=meta.tags": []int64{2},
+		"q=7":                        []int64{},
+		"q=":                         []int64{1, 2, 3},
+	}
+
+	for rawQuery, expectedIds := range tests {
+		query, _ := url.ParseQuery(rawQuery)
+
+		if ids := rowIds(searchRecords(rows, query)); !idsEqual(ids, expectedIds) {
+			t.Errorf("Query %q: expected %v, got %v", rawQuery, expectedIds, ids)
+		}
+	}
+}
+
+func TestSearchScore(t *testing.T) {
+	rows := []interface{}{
+		map[string]interface{}{"id": int64(1), "title": "Hello World", "body": "hello again"},
+	}
+
+	query, _ := url.ParseQuery("q=hello&q_score=true")
+	results := searchRecords(rows, query)
+
+	if len(results) != 1 {
+		t.Fatalf("Expected 1 result, got %d", len(results))
+	}
+
+	if score := results[0].(map[string]interface{})["_score"]; score != int64(2) {
+		t.Errorf("Expected a score of 2, got %v", score)
+	}
+
+	if _, ok := rows[0].(map[string]interface{})["_score"]; ok {
+		t.Error("The stored record should not have been modified")
+	}
+}
+
+func TestSearchCursorPagination(t *testing.T) {
+	rows := []interface{}{
+		map[string]interface{}{"id": int64(1), "title": "foo"},
+		map[string]interface{}{"id": int64(2), "title": "foo foo"},
+		map[string]interface{}{"id": int64(3), "title": "bar"},
+		map[string]interface{}{"id": int64(4), "title": "foo foo foo"},
+		map[string]interface{}{"id": int64(5), "title": "foo"},
+	}
+
+	// Without _sort the results are ordered by score, and the cursors have to follow that order
+	seen := []int64{}
+	path := "/posts?q=foo&q_score=true&_limit=2&_cursor="
+
+	for pages := 0; path != ""; pages++ {
+		if pages > 3 {
+			t.Fatalf("Cursor pagination did not terminate, got %v so far", seen)
+		}
+
+		w := httptest.NewRecorder()
+		page, err := queryRecords(w, httptest.NewRequest("GET", path, nil), "posts", rows)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		seen = append(seen, rowIds(page)...)
+
+		path = ""
+		if cursor := w.Header().Get("X-Next-Cursor"); cursor != "" {
+			path = "/posts?q=foo&q_score=true&_limit=2&_cursor=" + url.QueryEscape(cursor)
+		}
+	}
+
+	if expected := []int64{4, 2, 1, 5}; !idsEqual(seen, expected) {
+		t.Errorf("Expected %v, got %v", expected, seen)
+	}
+}
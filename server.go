@@ -10,7 +10,9 @@
 //
 // Start qrest with this file as an argument:
 //
-//    qrest db.json
+//    qrest serve db.json
+//
+// Run `qrest -h` for the other commands and `qrest serve -h` for the flags.
 //
 // Or in a docker container:
 //
@@ -30,7 +32,13 @@
 package main
 
 import (
+	"context"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/codegangsta/negroni"
@@ -38,6 +46,11 @@ import (
 	nlogrus "github.com/meatballhat/negroni-logrus"
 )
 
+// shutdownTimeout is how long in-flight requests are given to complete when the server is stopped. It is shorter
+// than the 10 seconds `docker stop` waits before killing the process, so that there is time for the final flush.
+//
+const shutdownTimeout = 5 * time.Second
+
 var (
 	loggerMiddleware *nlogrus.Middleware
 	logger           *logrus.Logger
@@ -49,35 +62,143 @@ func init() {
 }
 
 func main() {
-	// TODO: Should probably use `flag` package
-	if len(os.Args) != 2 {
-		logger.Println(os.Args)
-		logger.Fatalf("Invalid number of arguments. Usage: %s /path/to/db.json", os.Args[0])
+	os.Exit(runCli(os.Args[1:]))
+}
+
+// StartServer serves the API on `addr` until the process receives SIGINT or SIGTERM, and then shuts it down (see
+// Shutdown)
+//
+func StartServer(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
 	}
 
-	parseJsonFile(os.Args[2])
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
 
-	port := ":" + os.Getenv("PORT")
-	if port == ":" {
-		port = ":3000"
+	logger.Infof("Listening on %s\n", addr)
+	server := serveInBackground(listener)
+
+	select {
+	case err := <-server.served:
+		server.stopFlushing()
+		return err
+	case signal := <-signals:
+		logger.Infof("Received %s, shutting down\n", signal)
 	}
 
-	StartServer(port)
+	return server.Shutdown()
 }
 
-func StartServer(addr string) {
+// backgroundServer is an instance of the API started by serveInBackground
+//
+type backgroundServer struct {
+	server  *http.Server
+	served  chan error
+	stopped chan struct{}
+	flushed chan error
+	watched chan struct{}
+}
+
+// serveInBackground serves the API on `listener` from another goroutine, reloading the data and configuration when
+// their files change (if `config.Watch` is set)
+//
+func serveInBackground(listener net.Listener) *backgroundServer {
+	handler := newReloadableHandler(buildHandler)
+
+	s := &backgroundServer{
+		server:  &http.Server{Handler: handler},
+		served:  make(chan error, 1),
+		stopped: make(chan struct{}),
+		flushed: make(chan error, 1),
+		watched: make(chan struct{}),
+	}
+
+	// This goroutine will flush the JSON to the db.json file according to the flush policy,
+	// AND once the server has stopped
+	go func(filename, policy string, interval time.Duration) {
+		s.flushed <- flushJson(filename, policy, interval, s.stopped)
+	}(JsonFilePath, config.FlushPolicy, config.FlushInterval)
+
+	go func(watch bool, dataFile, configFile string, interval time.Duration) {
+		defer close(s.watched)
+
+		if watch {
+			watchFiles(handler, dataFile, configFile, interval, s.stopped)
+		}
+	}(config.Watch, JsonFilePath, ConfigFilePath, config.WatchInterval)
+
+	go func() {
+		s.served <- s.server.Serve(listener)
+	}()
 
+	return s
+}
+
+// buildHandler adds the routes for the current data and wraps them in the middleware the configuration asks for. It
+// is called again whenever the data or configuration are reloaded.
+//
+func buildHandler() http.Handler {
 	router := httprouter.New()
 
 	addStaticRoutes(router)
+	addAdminRoutes(router)
 	addDynamicRoutes(router)
 
-	// This goroutine will flush the JSON to the db.json file every 30 seconds,
-	// OR before the application exits
-	go flushJson(JsonFilePath)
-
 	n := negroni.Classic()
 	n.Use(loggerMiddleware)
+	n.Use(negroni.HandlerFunc(writeThroughMiddleware))
+
+	if config.Delay > 0 {
+		n.Use(delayMiddleware(config.Delay))
+	}
+
+	if config.Prefix != "" {
+		n.Use(prefixMiddleware(config.Prefix))
+	}
+
+	if len(config.Routes) > 0 {
+		n.Use(rewriteMiddleware(config.Routes))
+	}
+
+	if config.Auth.Enabled() {
+		n.Use(authMiddleware(config.Auth))
+	}
+
+	if config.ReadOnly {
+		n.Use(negroni.HandlerFunc(readOnlyMiddleware))
+	}
+
 	n.UseHandler(router)
-	n.Run(addr)
+
+	return n
+}
+
+// stopFlushing stops watching the files and stops the flush loop, which flushes the data one final time, and returns
+// the error of that flush. It must only be called once.
+//
+func (s *backgroundServer) stopFlushing() error {
+	close(s.stopped)
+	<-s.watched
+
+	return <-s.flushed
+}
+
+// Shutdown stops accepting connections, waits up to shutdownTimeout for in-flight requests to complete and flushes
+// the data one final time. An error is returned if the requests didn't complete in time or the final flush failed.
+// It must only be called once.
+//
+func (s *backgroundServer) Shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	err := s.server.Shutdown(ctx)
+
+	if flushErr := s.stopFlushing(); flushErr != nil {
+		return flushErr
+	}
+
+	return err
 }
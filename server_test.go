@@ -3,7 +3,10 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -42,10 +45,110 @@ func TestMain(m *testing.M) {
 		}
 
 		logger.Out = ioutil.Discard
-		go StartServer(TestServerAddr)
+
+		// Tests replace serverData and write other files, which mustn't be mistaken for edits to the test file
+		config.Watch = false
+
+		listener, err := net.Listen("tcp", TestServerAddr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		serveInBackground(listener)
 	} else {
 		fmt.Fprintln(os.Stderr, "could not create temp file")
 	}
 
 	os.Exit(m.Run())
 }
+
+func TestGracefulShutdown(t *testing.T) {
+	databaseBeforeModification := serverData.Copy()
+	defer func() {
+		serverData = databaseBeforeModification
+	}()
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := serveInBackground(listener)
+	url := fmt.Sprintf("http://%s/profile", listener.Addr())
+
+	request, _ := http.NewRequest("PATCH", url, strings.NewReader(`{"name": "Shutdown"}`))
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp.Body.Close()
+
+	if err := server.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The change is flushed even though the flush interval hasn't passed
+	contents, err := ioutil.ReadFile(JsonFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(contents), `"name":"Shutdown"`) {
+		t.Errorf("Expected the change to be flushed, got %s", contents)
+	}
+
+	if _, err := http.Get(url); err == nil {
+		t.Error("Expected the server to stop accepting connections")
+	}
+}
+
+func TestAutoCreateCollections(t *testing.T) {
+	defer disableWal()()
+
+	databaseBeforeModification, configBeforeModification := serverData.Copy(), config
+	defer func() {
+		serverData, config = databaseBeforeModification, configBeforeModification
+	}()
+
+	config.AutoCreate, config.FlushPolicy = true, FlushNever
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := serveInBackground(listener)
+	defer server.Shutdown()
+
+	request := func(method, path, body string) int {
+		req, _ := http.NewRequest(method, fmt.Sprintf("http://%s%s", listener.Addr(), path), strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	if status := request("POST", "/gadgets", `{"name": "Foo"}`); status != http.StatusCreated {
+		t.Fatalf("Expected the collection to be created, got %d", status)
+	}
+
+	if status := request("GET", "/gadgets/1", ""); status != http.StatusOK {
+		t.Errorf("Expected the created record to be served, got %d", status)
+	}
+
+	for _, method := range []string{"GET", "PUT", "DELETE"} {
+		if status := request(method, "/missing", `{}`); status != http.StatusNotFound {
+			t.Errorf("Expected %s of a missing collection not to create it, got %d", method, status)
+		}
+	}
+
+	if status := request("POST", "/_reserved", `{}`); status != http.StatusNotFound {
+		t.Errorf("Expected a reserved name not to be created, got %d", status)
+	}
+}
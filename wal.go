@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Operations recorded in the write-ahead log
+//
+const (
+	walSnapshot = "snapshot"
+	walAdd      = "add"
+	walReplace  = "replace"
+	walDelete   = "delete"
+	walResource = "resource"
+	walCreate   = "create"
+	walDrop     = "drop"
+)
+
+// wal is the write-ahead log of serverData, opened by parseJsonFile. Every change made through the BackingData
+// methods is appended to it before the request completes, so changes made since the last flush survive a crash.
+//
+var wal *writeAheadLog
+
+// walEntry is a single line of the write-ahead log. Records are identified by their position within the collection
+// rather than their ID (which may be missing or duplicated), which is deterministic when the log is replayed in order
+// against the snapshot it was started for. The first entry of the log is always a walSnapshot holding the checksum
+// of that snapshot.
+//
+type walEntry struct {
+	Op       string                 `json:"op"`
+	Type     string                 `json:"type,omitempty"`
+	Index    int                    `json:"index,omitempty"`
+	Record   map[string]interface{} `json:"record,omitempty"`
+	Records  []interface{}          `json:"records,omitempty"`
+	Checksum string                 `json:"checksum,omitempty"`
+}
+
+type writeAheadLog struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// walPath returns the path of the write-ahead log of the JSON file at `dbPath`
+//
+func walPath(dbPath string) string {
+	return dbPath + ".wal"
+}
+
+// dataChecksum identifies the contents of a snapshot
+//
+func dataChecksum(jsonData []byte) string {
+	sum := sha256.Sum256(jsonData)
+
+	return hex.EncodeToString(sum[:])
+}
+
+// readWal reads the entries of the log at `path`. If the log was started for a different snapshot than the one
+// with `checksum` (e.g. the server crashed after writing a new snapshot but before resetting the log), it has
+// already been applied and no entries are returned. An entry which was only partially written is ignored. The
+// second return value is the length of the log up to the end of the last complete entry.
+//
+func readWal(path string, checksum string) ([]walEntry, int64, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, 0, nil
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	entries := []walEntry{}
+	size := int64(0)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, 0, err
+		}
+
+		entry, err := decodeWalEntry(line)
+		if err != nil {
+			logger.Errorf("Ignoring the rest of %s: %s\n", path, err)
+			break
+		}
+
+		if size == 0 && (entry.Op != walSnapshot || entry.Checksum != checksum) {
+			return nil, 0, nil
+		}
+
+		if entry.Op != walSnapshot {
+			entries = append(entries, entry)
+		}
+
+		size += int64(len(line))
+	}
+
+	return entries, size, nil
+}
+
+// decodeWalEntry decodes a line of the log. Numbers are decoded the same way as the JSON file's (see decodeJson).
+//
+func decodeWalEntry(line []byte) (walEntry, error) {
+	data := make(map[string]interface{})
+	if err := decodeJson(bytes.NewReader(line), &data); err != nil {
+		return walEntry{}, err
+	}
+
+	entry := walEntry{}
+	entry.Op, _ = data["op"].(string)
+	entry.Type, _ = data["type"].(string)
+	entry.Record, _ = data["record"].(map[string]interface{})
+	entry.Records, _ = data["records"].([]interface{})
+	entry.Checksum, _ = data["checksum"].(string)
+
+	if index, ok := data["index"].(int64); ok {
+		entry.Index = int(index)
+	}
+
+	return entry, nil
+}
+
+// replayWal applies the entries read by readWal. The log must not be open while it is replayed, otherwise the
+// entries would be logged again.
+//
+func (b BackingData) replayWal(entries []walEntry) error {
+	for _, entry := range entries {
+		switch entry.Op {
+		case walAdd:
+			b.AddRecord(entry.Type, entry.Record)
+			continue
+		case walResource:
+			b.SetResource(entry.Type, entry.Record)
+			continue
+		case walCreate:
+			if err := b.CreateCollection(entry.Type, entry.Records); err != nil {
+				return fmt.Errorf("write-ahead log entry %s %s does not match the data", entry.Op, entry.Type)
+			}
+
+			continue
+		case walDrop:
+			if err := b.DropCollection(entry.Type); err != nil {
+				return fmt.Errorf("write-ahead log entry %s %s does not match the data", entry.Op, entry.Type)
+			}
+
+			continue
+		}
+
+		collection, err := b.collection(entry.Type)
+		if err != nil || entry.Index < 0 || entry.Index >= len(collection.Rows) {
+			return fmt.Errorf("write-ahead log entry %s %s %d does not match the data", entry.Op, entry.Type, entry.Index)
+		}
+
+		switch entry.Op {
+		case walReplace:
+			collection.replaceAt(entry.Index, entry.Record)
+		case walDelete:
+			collection.removeAt(entry.Index)
+		default:
+			return fmt.Errorf("unknown write-ahead log entry %s", entry.Op)
+		}
+	}
+
+	return nil
+}
+
+// openWal opens the log at `path` for appending. Anything after the first `size` bytes (see readWal) is discarded,
+// and if the log is empty it is started for the snapshot with `checksum`.
+//
+func openWal(path string, checksum string, size int64) (*writeAheadLog, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	log := &writeAheadLog{file: file}
+
+	if size == 0 {
+		return log, log.reset(checksum)
+	}
+
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	if _, err := file.Seek(size, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return log, nil
+}
+
+// append writes `entry` to the log and waits for it to reach the disk. Appending to a nil log does nothing.
+//
+func (l *writeAheadLog) append(entry walEntry) {
+	if l == nil {
+		return
+	}
+
+	jsonData, err := json.Marshal(entry)
+	if err != nil {
+		logger.Error(err)
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if _, err := l.file.Write(append(jsonData, '\n')); err != nil {
+		logger.Error(err)
+		return
+	}
+
+	if err := l.file.Sync(); err != nil {
+		logger.Error(err)
+	}
+}
+
+// reset empties the log after a snapshot with `checksum` has been written. Resetting a nil log does nothing.
+//
+func (l *writeAheadLog) reset(checksum string) error {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if err := l.file.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err := l.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	jsonData, err := json.Marshal(walEntry{Op: walSnapshot, Checksum: checksum})
+	if err != nil {
+		return err
+	}
+
+	if _, err := l.file.Write(append(jsonData, '\n')); err != nil {
+		return err
+	}
+
+	return l.file.Sync()
+}
+
+func (l *writeAheadLog) Close() error {
+	if l == nil {
+		return nil
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	return l.file.Close()
+}
+
+// writeFileAtomically replaces the file at `filename` with `data`. The data is written to a temporary file which is
+// renamed over the original once it is on disk, so a crash leaves either the old or the new file but never a partial
+// one. The directory is synced after the rename, since the write-ahead log is reset for the new file right after.
+//
+func writeFileAtomically(filename string, data []byte) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filename); err == nil {
+		mode = info.Mode()
+	}
+
+	tempFile, err := ioutil.TempFile(filepath.Dir(filename), filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(tempFile.Name())
+
+	if _, err := tempFile.Write(data); err != nil {
+		tempFile.Close()
+		return err
+	}
+
+	if err := tempFile.Sync(); err != nil {
+		tempFile.Close()
+		return err
+	}
+
+	if err := tempFile.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tempFile.Name(), mode); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tempFile.Name(), filename); err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(filename))
+}
+
+// syncDir waits for changes to the entries of the directory at `path` (such as a rename) to reach the disk
+//
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer dir.Close()
+
+	return dir.Sync()
+}
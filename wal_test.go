@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// disableWal stops changes from being logged to the test server's write-ahead log while a test modifies data
+// other than serverData. The returned function restores the log.
+//
+func disableWal() func() {
+	previous := wal
+	wal = nil
+
+	return func() {
+		wal = previous
+	}
+}
+
+func walTestDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "qrest-wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func TestWalReplay(t *testing.T) {
+	dir := walTestDir(t)
+	defer os.RemoveAll(dir)
+
+	path := walPath(filepath.Join(dir, "db.json"))
+
+	previous := wal
+	defer func() {
+		wal = previous
+	}()
+
+	log, err := openWal(path, "initial", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wal = log
+	data := integrityTestData()
+
+	setNull := []Relation{
+		Relation{Child: "posts", Parent: "users", ForeignKey: "userId", OnDelete: OnDeleteSetNull},
+	}
+
+	withRelations(t, setNull, func() {
+		data.AddRecord("posts", map[string]interface{}{"id": int64(3), "title": "Added", "price": 19.99})
+		data.ReplaceRecord("comments", int64(2), map[string]interface{}{"id": int64(2), "postId": int64(3)})
+		data.DeleteRecordWithRelations("users", int64(1))
+		data.SetResource("profile", map[string]interface{}{"name": "Foo"})
+		data.CreateCollection("tags", []interface{}{map[string]interface{}{"id": int64(1)}})
+		data.AddRecord("tags", map[string]interface{}{"id": int64(2)})
+		data.DropCollection("users")
+	})
+
+	wal = previous
+	log.Close()
+
+	entries, _, err := readWal(path, "initial")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 9 {
+		t.Errorf("Expected 9 entries, got %d", len(entries))
+	}
+
+	replayed := integrityTestData()
+	if err := replayed.replayWal(entries); err != nil {
+		t.Fatal(err)
+	}
+
+	expected, _ := json.Marshal(data)
+	actual, _ := json.Marshal(replayed)
+
+	if string(expected) != string(actual) {
+		t.Errorf("Expected %s, got %s", expected, actual)
+	}
+
+	// A log started for another snapshot has already been applied
+	if entries, size, _ := readWal(path, "other"); len(entries) != 0 || size != 0 {
+		t.Errorf("Expected a stale log to be ignored, got %d entries", len(entries))
+	}
+}
+
+func TestWalPartialEntry(t *testing.T) {
+	dir := walTestDir(t)
+	defer os.RemoveAll(dir)
+
+	path := walPath(filepath.Join(dir, "db.json"))
+
+	log, err := openWal(path, "initial", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log.append(walEntry{Op: walAdd, Type: "posts", Record: map[string]interface{}{"id": int64(1)}})
+	log.file.Write([]byte(`{"op":"add","type":"po`))
+	log.Close()
+
+	entries, size, err := readWal(path, "initial")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("Expected the partial entry to be ignored, got %d entries", len(entries))
+	}
+
+	// Reopening the log discards the partial entry so that new entries follow the last complete one
+	log, err = openWal(path, "initial", size)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	log.append(walEntry{Op: walDelete, Type: "posts", Index: 0})
+	log.Close()
+
+	entries, _, err = readWal(path, "initial")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 2 || entries[1].Op != walDelete {
+		t.Errorf("Expected an add and a delete, got %#v", entries)
+	}
+}
+
+func TestWalReset(t *testing.T) {
+	dir := walTestDir(t)
+	defer os.RemoveAll(dir)
+
+	path := walPath(filepath.Join(dir, "db.json"))
+
+	log, err := openWal(path, "initial", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer log.Close()
+
+	log.append(walEntry{Op: walAdd, Type: "posts", Record: map[string]interface{}{"id": int64(1)}})
+
+	if err := log.reset("flushed"); err != nil {
+		t.Fatal(err)
+	}
+
+	log.append(walEntry{Op: walDelete, Type: "posts", Index: 0})
+
+	entries, _, err := readWal(path, "flushed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Op != walDelete {
+		t.Errorf("Expected only the entry after the reset, got %#v", entries)
+	}
+}
+
+func TestWriteFileAtomically(t *testing.T) {
+	dir := walTestDir(t)
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "db.json")
+	ioutil.WriteFile(filename, []byte(`{}`), 0600)
+
+	if err := writeFileAtomically(filename, []byte(`{"posts":[]}`)); err != nil {
+		t.Fatal(err)
+	}
+
+	if contents, _ := ioutil.ReadFile(filename); string(contents) != `{"posts":[]}` {
+		t.Errorf("Unexpected contents %s", contents)
+	}
+
+	if info, _ := os.Stat(filename); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the file mode to be kept, got %s", info.Mode())
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("Expected the temporary file to be removed, got %d files", len(files))
+	}
+
+	if err := syncDir(dir); err != nil {
+		t.Errorf("Expected the directory to be synced, got %s", err)
+	}
+
+	if err := writeFileAtomically(filepath.Join(dir, "missing", "db.json"), []byte(`{}`)); err == nil {
+		t.Error("Expected an error for a missing directory")
+	}
+}